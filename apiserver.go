@@ -0,0 +1,538 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Status of a plan submitted to the API server
+const (
+	planStatusPlanned  = "planned"
+	planStatusApproved = "approved"
+	planStatusApplied  = "applied"
+	planStatusFailed   = "failed"
+)
+
+// maxUploadSize limits the size of a submitted file
+const maxUploadSize = 32 << 20
+
+var planIDPattern = regexp.MustCompile(`^[0-9a-f]{16}$`)
+
+// apiServer accepts survey files from several users, plans them and
+// applies approved plans using one shared OSM account. Plans are stored
+// in the server directory to survive restarts.
+type apiServer struct {
+	dir       string
+	keys      map[string]string
+	osmClient *osm.Client
+	policy    *updatePolicy
+
+	// lock serializes all access to the OSM API and the stored plans as
+	// the planning and applying code works on global state
+	lock sync.Mutex
+}
+
+type serverPlan struct {
+	ID          string     `json:"id"`
+	Status      string     `json:"status"`
+	Filename    string     `json:"filename"`
+	SubmittedBy string     `json:"submitted_by"`
+	SubmittedAt time.Time  `json:"submitted_at"`
+	ApprovedBy  string     `json:"approved_by,omitempty"`
+	ApprovedAt  *time.Time `json:"approved_at,omitempty"`
+	AppliedBy   string     `json:"applied_by,omitempty"`
+	AppliedAt   *time.Time `json:"applied_at,omitempty"`
+
+	// Warnings lists skipped waypoints and creates held back for review,
+	// they are not part of the plan
+	Warnings []string `json:"warnings"`
+	Errors   []string `json:"errors,omitempty"`
+
+	Plan   *planFile  `json:"plan,omitempty"`
+	Report *runReport `json:"report,omitempty"`
+}
+
+type auditEntry struct {
+	Time    time.Time `json:"time"`
+	User    string    `json:"user"`
+	Action  string    `json:"action"`
+	PlanID  string    `json:"plan_id"`
+	Message string    `json:"message,omitempty"`
+}
+
+func runServer() {
+	validateRemovalMode()
+
+	if cfg.ServerKeys == "" {
+		log.Fatalf("server-keys is a required parameter for server")
+	}
+
+	keys, err := loadServerKeys(cfg.ServerKeys)
+	if err != nil {
+		log.Fatalf("Unable to load server keys: %s", err)
+	}
+
+	policy, err := loadUpdatePolicy(cfg.PolicyFile)
+	if err != nil {
+		log.Fatalf("Unable to load update policy: %s", err)
+	}
+
+	if err := os.MkdirAll(cfg.ServerDir, 0755); err != nil {
+		log.Fatalf("Unable to create server directory: %s", err)
+	}
+
+	s := &apiServer{
+		dir:       cfg.ServerDir,
+		keys:      keys,
+		osmClient: newOSMClient(),
+		policy:    policy,
+	}
+
+	// Plans are approved through the API
+	cfg.Interactive = false
+
+	http.HandleFunc("/api/plans", s.authenticated(s.handlePlans))
+	http.HandleFunc("/api/plans/", s.authenticated(s.handlePlan))
+
+	log.Infof("Serving API for %d users on http://%s/", len(keys), cfg.Listen)
+	log.Fatalf("HTTP server failed: %s", http.ListenAndServe(cfg.Listen, nil))
+}
+
+// loadServerKeys reads a YAML file mapping user names to their API keys
+// and returns the users by key
+func loadServerKeys(filename string) (map[string]string, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	users := map[string]string{}
+	if err := yaml.Unmarshal(data, &users); err != nil {
+		return nil, err
+	}
+
+	keys := map[string]string{}
+	for user, key := range users {
+		if key == "" {
+			return nil, fmt.Errorf("User %s has no key", user)
+		}
+		if _, ok := keys[key]; ok {
+			return nil, fmt.Errorf("Key of user %s is not unique", user)
+		}
+		keys[key] = user
+	}
+
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("No users defined")
+	}
+
+	return keys, nil
+}
+
+// authenticated resolves the user from the bearer token and rejects
+// requests without valid key
+func (s *apiServer) authenticated(h func(http.ResponseWriter, *http.Request, string)) http.HandlerFunc {
+	return func(res http.ResponseWriter, r *http.Request) {
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+
+		user := ""
+		for key, u := range s.keys {
+			if subtle.ConstantTimeCompare([]byte(key), []byte(token)) == 1 {
+				user = u
+			}
+		}
+
+		if user == "" {
+			writeJSON(res, http.StatusUnauthorized, map[string]string{"error": "Missing or invalid API key"})
+			return
+		}
+
+		h(res, r, user)
+	}
+}
+
+func (s *apiServer) handlePlans(res http.ResponseWriter, r *http.Request, user string) {
+	switch r.Method {
+	case http.MethodGet:
+		s.listPlans(res)
+	case http.MethodPost:
+		s.submitPlan(res, r, user)
+	default:
+		writeJSON(res, http.StatusMethodNotAllowed, map[string]string{"error": "GET or POST required"})
+	}
+}
+
+// handlePlan serves /api/plans/<id>, /api/plans/<id>/approve and
+// /api/plans/<id>/apply
+func (s *apiServer) handlePlan(res http.ResponseWriter, r *http.Request, user string) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/api/plans/"), "/")
+	if !planIDPattern.MatchString(parts[0]) || len(parts) > 2 {
+		writeJSON(res, http.StatusNotFound, map[string]string{"error": "Not found"})
+		return
+	}
+
+	action := ""
+	if len(parts) == 2 {
+		action = parts[1]
+	}
+
+	switch {
+	case action == "" && r.Method == http.MethodGet:
+		s.lock.Lock()
+		p, err := s.readPlan(parts[0])
+		s.lock.Unlock()
+		if err != nil {
+			writePlanError(res, err)
+			return
+		}
+		writeJSON(res, http.StatusOK, p)
+
+	case (action == "approve" || action == "apply") && r.Method == http.MethodPost:
+		s.changePlan(res, parts[0], action, user)
+
+	case action == "" || action == "approve" || action == "apply":
+		writeJSON(res, http.StatusMethodNotAllowed, map[string]string{"error": "Method not allowed"})
+
+	default:
+		writeJSON(res, http.StatusNotFound, map[string]string{"error": "Not found"})
+	}
+}
+
+func (s *apiServer) listPlans(res http.ResponseWriter) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	files, err := filepath.Glob(filepath.Join(s.dir, "*.plan.json"))
+	if err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	out := []*serverPlan{}
+	for _, f := range files {
+		p, err := s.readPlan(strings.TrimSuffix(filepath.Base(f), ".plan.json"))
+		if err != nil {
+			writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+			return
+		}
+		p.Plan, p.Report = nil, nil
+		out = append(out, p)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].SubmittedAt.Before(out[j].SubmittedAt) })
+
+	writeJSON(res, http.StatusOK, out)
+}
+
+// submitPlan stores the uploaded file and plans its changes. The file is
+// either sent as multipart form (fields file and comment) or as JSON
+// object with filename, content and comment.
+func (s *apiServer) submitPlan(res http.ResponseWriter, r *http.Request, user string) {
+	r.Body = http.MaxBytesReader(res, r.Body, maxUploadSize)
+
+	var (
+		filename, comment string
+		content           []byte
+		err               error
+	)
+
+	if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/form-data") {
+		filename, comment, content, err = readMultipartUpload(r)
+	} else {
+		req := struct {
+			Filename string `json:"filename"`
+			Content  string `json:"content"`
+			Comment  string `json:"comment"`
+		}{}
+		err = json.NewDecoder(r.Body).Decode(&req)
+		filename, comment, content = req.Filename, req.Comment, []byte(req.Content)
+	}
+
+	if err != nil {
+		writeJSON(res, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	filename = path.Base(filename)
+	if len(content) == 0 || detectFormat(filename, "") == "" {
+		writeJSON(res, http.StatusBadRequest, map[string]string{"error": "filename with extension and content are required"})
+		return
+	}
+
+	if comment == "" {
+		comment = cfg.Comment
+	}
+
+	id, err := newPlanID()
+	if err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	source := filepath.Join(s.dir, id+".source"+strings.ToLower(path.Ext(filename)))
+	if err := ioutil.WriteFile(source, content, 0644); err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, err := s.createPlan(id, source, filename, comment, user)
+	if err != nil {
+		os.Remove(source)
+		writeJSON(res, http.StatusUnprocessableEntity, map[string]string{"error": err.Error()})
+		return
+	}
+
+	if err := s.writePlan(p); err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.audit(user, "submit", id, fmt.Sprintf("%s with %d changes and %d warnings", filename, len(p.Plan.Changes), len(p.Warnings)))
+
+	writeJSON(res, http.StatusCreated, p)
+}
+
+func readMultipartUpload(r *http.Request) (string, string, []byte, error) {
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		return "", "", nil, err
+	}
+	defer r.MultipartForm.RemoveAll()
+
+	f, header, err := r.FormFile("file")
+	if err != nil {
+		return "", "", nil, err
+	}
+	defer f.Close()
+
+	content, err := ioutil.ReadAll(f)
+	return header.Filename, r.FormValue("comment"), content, err
+}
+
+func newPlanID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// createPlan plans the changes of the source file like the plan command
+func (s *apiServer) createPlan(id, source, filename, comment, user string) (*serverPlan, error) {
+	hydrants, skipped, bds, err := readHydrants(source, "")
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read file: %s", err)
+	}
+
+	if err := checkStrict(skipped); err != nil {
+		return nil, fmt.Errorf("Rejecting file: %s", err)
+	}
+
+	changes := []*plannedChange{}
+	if len(hydrants) > 0 {
+		mapData, err := fetchMapData(s.osmClient, bds, mapDataBorder)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to get map data: %s", err)
+		}
+
+		if changes, err = planChanges(hydrants, hydrantsFromMapData(mapData), s.policy, s.osmClient.CurrentUser.ID); err != nil {
+			return nil, fmt.Errorf("Unable to plan changes: %s", err)
+		}
+	}
+
+	p := &serverPlan{
+		ID:          id,
+		Status:      planStatusPlanned,
+		Filename:    filename,
+		SubmittedBy: user,
+		SubmittedAt: time.Now().UTC(),
+		Warnings:    []string{},
+		Plan:        newPlanFile(changes),
+	}
+	p.Plan.Comment, p.Plan.Source = comment, filename
+
+	for _, sw := range skipped {
+		p.Warnings = append(p.Warnings, fmt.Sprintf("Skipped waypoint %s: %s", sw.Waypoint.Name, sw.Reason))
+	}
+	for _, c := range changes {
+		logPolicyDecisions(c.Decisions)
+
+		switch c.Action {
+		case actionSkip:
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Skipped waypoint %s: %s", c.Hydrant.Name, c.Reason))
+		case actionReview:
+			p.Warnings = append(p.Warnings, fmt.Sprintf("Needs review: waypoint %s, %s", c.Hydrant.Name, c.Reason))
+		}
+	}
+
+	return p, nil
+}
+
+// changePlan approves or applies the plan
+func (s *apiServer) changePlan(res http.ResponseWriter, id, action, user string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	p, err := s.readPlan(id)
+	if err != nil {
+		writePlanError(res, err)
+		return
+	}
+
+	now := time.Now().UTC()
+
+	switch action {
+	case "approve":
+		if p.Status != planStatusPlanned {
+			writeJSON(res, http.StatusConflict, map[string]string{"error": fmt.Sprintf("Plan is %s", p.Status)})
+			return
+		}
+		if user == p.SubmittedBy {
+			writeJSON(res, http.StatusForbidden, map[string]string{"error": "Plans need to be approved by another user than the submitter"})
+			return
+		}
+		p.Status, p.ApprovedBy, p.ApprovedAt = planStatusApproved, user, &now
+		s.audit(user, "approve", id, "")
+
+	case "apply":
+		if p.Status != planStatusApproved && p.Status != planStatusFailed {
+			writeJSON(res, http.StatusConflict, map[string]string{"error": fmt.Sprintf("Plan is %s, only approved plans can be applied", p.Status)})
+			return
+		}
+
+		p.AppliedBy, p.AppliedAt = user, &now
+		if err := s.applyPlan(p); err != nil {
+			p.Status, p.Errors = planStatusFailed, []string{err.Error()}
+		}
+		s.audit(user, "apply", id, fmt.Sprintf("status %s, %d errors", p.Status, len(p.Errors)))
+	}
+
+	if err := s.writePlan(p); err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	writeJSON(res, http.StatusOK, p)
+}
+
+// applyPlan sends the changes of the plan in a new changeset. Writes are
+// recorded in a journal per plan to skip them when a failed plan is
+// applied again.
+func (s *apiServer) applyPlan(p *serverPlan) error {
+	if p.Plan.APIURL != cfg.OSM.APIURL {
+		return fmt.Errorf("Plan was created for %s, refusing to apply it to %s", p.Plan.APIURL, cfg.OSM.APIURL)
+	}
+
+	if err := p.Plan.checkDeletes(); err != nil {
+		return fmt.Errorf("Refusing to apply plan: %s", err)
+	}
+
+	j, err := openJournal(filepath.Join(s.dir, p.ID+".journal"))
+	if err != nil {
+		return fmt.Errorf("Unable to read journal: %s", err)
+	}
+
+	if err := p.Plan.checkBase(s.osmClient, cfg.Rebase, j); err != nil {
+		return fmt.Errorf("Refusing to apply plan: %s", err)
+	}
+
+	// Every plan gets its own changeset with the comment of the plan
+	defaultComment := cfg.Comment
+	defer func() { cfg.Comment, changeset = defaultComment, nil }()
+	cfg.Comment, changeset = p.Plan.Comment, nil
+
+	changes := p.Plan.plannedChanges()
+	if len(changes) > 0 {
+		if _, err := openChangeset(s.osmClient); err != nil {
+			return err
+		}
+	}
+
+	_, errs := applyChanges(changes, s.osmClient, j)
+
+	p.Report = newRunReport(changes, nil)
+	p.Report.Source = p.Filename
+
+	p.Status, p.Errors = planStatusApplied, nil
+	if len(errs) > 0 {
+		p.Status = planStatusFailed
+		for _, e := range errs {
+			p.Errors = append(p.Errors, e.Error())
+		}
+	}
+
+	return nil
+}
+
+func (s *apiServer) readPlan(id string) (*serverPlan, error) {
+	f, err := os.Open(filepath.Join(s.dir, id+".plan.json"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &serverPlan{}
+	return p, json.NewDecoder(f).Decode(p)
+}
+
+// writePlan replaces the stored plan through a temporary file to never
+// leave a partially written plan
+func (s *apiServer) writePlan(p *serverPlan) error {
+	filename := filepath.Join(s.dir, p.ID+".plan.json")
+
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(filename+".tmp", filename)
+}
+
+// audit appends an entry to the audit log of the server directory
+func (s *apiServer) audit(user, action, planID, message string) {
+	log.WithFields(log.Fields{"user": user, "plan": planID}).Info(strings.TrimSpace(action + " " + message))
+
+	f, err := os.OpenFile(filepath.Join(s.dir, "audit.log"), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		log.Errorf("Unable to open audit log: %s", err)
+		return
+	}
+	defer f.Close()
+
+	e := auditEntry{Time: time.Now().UTC(), User: user, Action: action, PlanID: planID, Message: message}
+	if err := json.NewEncoder(f).Encode(e); err != nil {
+		log.Errorf("Unable to write audit log: %s", err)
+	}
+}
+
+func writePlanError(res http.ResponseWriter, err error) {
+	if os.IsNotExist(err) {
+		writeJSON(res, http.StatusNotFound, map[string]string{"error": "Plan not found"})
+		return
+	}
+	writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+}
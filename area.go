@@ -0,0 +1,234 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Luzifer/gpxhydrant/geojson"
+	"github.com/Luzifer/gpxhydrant/poly"
+)
+
+var (
+	errOutsideArea = errors.New("waypoint is outside of the area")
+	errNoOverlap   = errors.New("no waypoints inside the area")
+
+	// surveyArea limits the hydrants to edit, filled from the area
+	// parameter
+	surveyArea *area
+)
+
+// area is a set of polygons with holes limiting the hydrants to edit
+type area struct {
+	polygons []areaPolygon
+}
+
+type areaPolygon struct {
+	outer areaRing
+	holes []areaRing
+}
+
+// areaRing contains the coordinates as longitude, latitude pairs
+type areaRing [][2]float64
+
+// loadArea reads the area from a GeoJSON file containing Polygon and
+// MultiPolygon features or from an Osmosis .poly file
+func loadArea(filename string) (*area, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var a *area
+	if strings.ToLower(path.Ext(filename)) == ".poly" {
+		a, err = areaFromPoly(f)
+	} else {
+		a, err = areaFromGeoJSON(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(a.polygons) == 0 {
+		return nil, errors.New("File contains no polygons")
+	}
+
+	return a, nil
+}
+
+func areaFromPoly(in *os.File) (*area, error) {
+	p, err := poly.ParsePolyData(in)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &area{}
+	for _, s := range p.Sections {
+		if !s.Hole {
+			a.polygons = append(a.polygons, areaPolygon{outer: areaRing(s.Points)})
+		}
+	}
+
+	// Holes are not assigned to an outer ring in .poly files, they belong
+	// to the polygon containing them
+	for _, s := range p.Sections {
+		if !s.Hole || len(s.Points) == 0 {
+			continue
+		}
+		for i := range a.polygons {
+			if a.polygons[i].outer.contains(s.Points[0][0], s.Points[0][1]) {
+				a.polygons[i].holes = append(a.polygons[i].holes, areaRing(s.Points))
+			}
+		}
+	}
+
+	return a, nil
+}
+
+func areaFromGeoJSON(in *os.File) (*area, error) {
+	fc, err := geojson.ParseGeoJSONData(in)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &area{}
+	for i, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+
+		polygons := [][][][]float64{}
+		switch f.Geometry.Type {
+		case "Polygon":
+			p, err := f.Geometry.Polygon()
+			if err != nil {
+				return nil, fmt.Errorf("Feature %d: %s", i, err)
+			}
+			polygons = append(polygons, p)
+
+		case "MultiPolygon":
+			if polygons, err = f.Geometry.MultiPolygon(); err != nil {
+				return nil, fmt.Errorf("Feature %d: %s", i, err)
+			}
+
+		default:
+			continue
+		}
+
+		for _, p := range polygons {
+			ap := areaPolygon{}
+			for j, r := range p {
+				ring, err := geoJSONRing(r)
+				if err != nil {
+					return nil, fmt.Errorf("Feature %d: %s", i, err)
+				}
+				if j == 0 {
+					ap.outer = ring
+				} else {
+					ap.holes = append(ap.holes, ring)
+				}
+			}
+			if len(ap.outer) > 0 {
+				a.polygons = append(a.polygons, ap)
+			}
+		}
+	}
+
+	return a, nil
+}
+
+func geoJSONRing(in [][]float64) (areaRing, error) {
+	out := areaRing{}
+	for _, c := range in {
+		if len(c) < 2 {
+			return nil, errors.New("Position needs at least two coordinates")
+		}
+		out = append(out, [2]float64{c[0], c[1]})
+	}
+	return out, nil
+}
+
+// Contains checks whether the position is inside the area, a nil area
+// contains every position
+func (a *area) Contains(lat, lon float64) bool {
+	if a == nil {
+		return true
+	}
+
+	for _, p := range a.polygons {
+		if !p.outer.contains(lon, lat) {
+			continue
+		}
+
+		inHole := false
+		for _, h := range p.holes {
+			inHole = inHole || h.contains(lon, lat)
+		}
+		if !inHole {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Hydrants returns the hydrants inside the area
+func (a *area) Hydrants(hydrants []*hydrant) []*hydrant {
+	out := []*hydrant{}
+	for _, h := range hydrants {
+		if a.Contains(h.Latitude, h.Longitude) {
+			out = append(out, h)
+		}
+	}
+	return out
+}
+
+// Bounds returns the bounding box of all outer rings
+func (a *area) Bounds() bounds {
+	bds := bounds{MinLat: 9999, MinLon: 9999, MaxLat: -9999, MaxLon: -9999}
+	for _, p := range a.polygons {
+		for _, c := range p.outer {
+			bds.Update(c[1], c[0])
+		}
+	}
+	return bds
+}
+
+// Clip restricts the bounds to the bounding box of the area. An error is
+// returned if the bounds do not overlap the area.
+func (a *area) Clip(bds bounds) (bounds, error) {
+	if a == nil {
+		return bds, nil
+	}
+
+	ab := a.Bounds()
+	out := bounds{
+		MinLat: math.Max(bds.MinLat, ab.MinLat),
+		MinLon: math.Max(bds.MinLon, ab.MinLon),
+		MaxLat: math.Min(bds.MaxLat, ab.MaxLat),
+		MaxLon: math.Min(bds.MaxLon, ab.MaxLon),
+	}
+
+	if out.MinLat > out.MaxLat || out.MinLon > out.MaxLon {
+		return out, errNoOverlap
+	}
+
+	return out, nil
+}
+
+// contains implements the even-odd rule: A ray starting at the position
+// crosses the ring an odd number of times if the position is inside
+func (r areaRing) contains(x, y float64) bool {
+	inside := false
+	for i, j := 0, len(r)-1; i < len(r); j, i = i, i+1 {
+		xi, yi, xj, yj := r[i][0], r[i][1], r[j][0], r[j][1]
+		if (yi > y) != (yj > y) && x < (xj-xi)*(y-yi)/(yj-yi)+xi {
+			inside = !inside
+		}
+	}
+	return inside
+}
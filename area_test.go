@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testArea is a square from 9.0,53.0 to 10.0,54.0 with a hole from
+// 9.4,53.4 to 9.6,53.6 and a second square from 11.0,53.0 to 11.5,53.5
+var testArea = &area{polygons: []areaPolygon{
+	{
+		outer: areaRing{{9, 53}, {10, 53}, {10, 54}, {9, 54}, {9, 53}},
+		holes: []areaRing{{{9.4, 53.4}, {9.6, 53.4}, {9.6, 53.6}, {9.4, 53.6}, {9.4, 53.4}}},
+	},
+	{
+		outer: areaRing{{11, 53}, {11.5, 53}, {11.5, 53.5}, {11, 53.5}, {11, 53}},
+	},
+}}
+
+var testAreaPositions = []struct {
+	Name     string
+	Lat, Lon float64
+	Inside   bool
+}{
+	{"inside first polygon", 53.2, 9.2, true},
+	{"inside hole", 53.5, 9.5, false},
+	{"between hole and outer ring", 53.5, 9.7, true},
+	{"inside second polygon", 53.25, 11.25, true},
+	{"between polygons", 53.25, 10.5, false},
+	{"north of first polygon", 54.1, 9.5, false},
+	{"west of first polygon", 53.5, 8.9, false},
+}
+
+func TestAreaContains(t *testing.T) {
+	for _, c := range testAreaPositions {
+		if got := testArea.Contains(c.Lat, c.Lon); got != c.Inside {
+			t.Errorf("%s (%f,%f): expected %v, got %v", c.Name, c.Lat, c.Lon, c.Inside, got)
+		}
+	}
+
+	var nilArea *area
+	if !nilArea.Contains(53.5, 9.5) {
+		t.Errorf("Expected nil area to contain every position")
+	}
+}
+
+func TestLoadArea(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpxhydrant")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"area.geojson": `{"type": "Feature", "properties": {}, "geometry": {"type": "MultiPolygon", "coordinates": [
+			[[[9, 53], [10, 53], [10, 54], [9, 54], [9, 53]], [[9.4, 53.4], [9.6, 53.4], [9.6, 53.6], [9.4, 53.6], [9.4, 53.4]]],
+			[[[11, 53], [11.5, 53], [11.5, 53.5], [11, 53.5], [11, 53]]]
+		]}}`,
+		"area.poly": "test\n1\n  9 53\n  10 53\n  10 54\n  9 54\nEND\n!1\n  9.4 53.4\n  9.6 53.4\n  9.6 53.6\n  9.4 53.6\nEND\n2\n  11 53\n  11.5 53\n  11.5 53.5\n  11 53.5\nEND\nEND\n",
+	}
+
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := ioutil.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatalf("Unable to write %s: %s", name, err)
+		}
+
+		a, err := loadArea(filename)
+		if err != nil {
+			t.Errorf("%s: Unable to load area: %s", name, err)
+			continue
+		}
+
+		for _, c := range testAreaPositions {
+			if got := a.Contains(c.Lat, c.Lon); got != c.Inside {
+				t.Errorf("%s, %s (%f,%f): expected %v, got %v", name, c.Name, c.Lat, c.Lon, c.Inside, got)
+			}
+		}
+	}
+}
+
+func TestAreaClip(t *testing.T) {
+	for _, c := range []struct {
+		Name     string
+		Bounds   bounds
+		Expected bounds
+		Err      error
+	}{
+		{
+			Name:     "inside the area",
+			Bounds:   bounds{MinLat: 53.2, MinLon: 9.2, MaxLat: 53.3, MaxLon: 9.3},
+			Expected: bounds{MinLat: 53.2, MinLon: 9.2, MaxLat: 53.3, MaxLon: 9.3},
+		},
+		{
+			Name:     "overlapping the edge",
+			Bounds:   bounds{MinLat: 53.9, MinLon: 8.5, MaxLat: 54.5, MaxLon: 9.5},
+			Expected: bounds{MinLat: 53.9, MinLon: 9, MaxLat: 54, MaxLon: 9.5},
+		},
+		{
+			Name:   "north of the area",
+			Bounds: bounds{MinLat: 54.1, MinLon: 9.2, MaxLat: 54.2, MaxLon: 9.3},
+			Err:    errNoOverlap,
+		},
+		{
+			Name:   "west of the area",
+			Bounds: bounds{MinLat: 53.2, MinLon: 8.2, MaxLat: 53.3, MaxLon: 8.3},
+			Err:    errNoOverlap,
+		},
+	} {
+		got, err := testArea.Clip(c.Bounds)
+		if err != c.Err {
+			t.Errorf("%s: expected error %v, got %v", c.Name, c.Err, err)
+			continue
+		}
+		if err == nil && got != c.Expected {
+			t.Errorf("%s: expected %#v, got %#v", c.Name, c.Expected, got)
+		}
+	}
+
+	var nilArea *area
+	bds := bounds{MinLat: 54.1, MinLon: 9.2, MaxLat: 54.2, MaxLon: 9.3}
+	if got, err := nilArea.Clip(bds); err != nil || got != bds {
+		t.Errorf("Expected nil area to keep the bounds, got %#v (%v)", got, err)
+	}
+}
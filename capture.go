@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+	"github.com/Luzifer/gpxhydrant/nmea"
+	log "github.com/Sirupsen/logrus"
+)
+
+func runCapture() {
+	requireGPXFile()
+
+	if cfg.NMEASource == "" {
+		log.Fatalf("nmea-source is a required parameter for capture")
+	}
+
+	src, live, err := nmea.Open(cfg.NMEASource)
+	if err != nil {
+		log.Fatalf("Unable to open NMEA source: %s", err)
+	}
+	defer src.Close()
+
+	fixes := streamFixes(nmea.NewReader(src), live)
+
+	if err := captureWaypoints(os.Stdin, os.Stdout, fixes, cfg.GPXFile, int(cfg.CaptureFixes)); err != nil {
+		log.Fatalf("Capture failed: %s", err)
+	}
+}
+
+// streamFixes reads fixes from the NMEA reader into the returned channel
+// which is closed at the end of the stream. Fixes from live sources are
+// discarded while nobody is waiting for them to always get current
+// positions, fixes from replayed files are all delivered.
+func streamFixes(r *nmea.Reader, live bool) <-chan nmea.Fix {
+	fixes := make(chan nmea.Fix)
+
+	go func() {
+		defer close(fixes)
+
+		for {
+			fix, err := r.Next()
+			if err != nil {
+				if err != io.EOF {
+					log.Errorf("Unable to read NMEA source: %s", err)
+				}
+				return
+			}
+
+			if !live {
+				fixes <- fix
+				continue
+			}
+
+			select {
+			case fixes <- fix:
+			default:
+			}
+		}
+	}()
+
+	return fixes
+}
+
+// captureWaypoints reads hydrant codes from the input, averages the next
+// fixes for each code and appends them as waypoint to the GPX file. An
+// empty line repeats the last code, "q" quits the capture.
+func captureWaypoints(in io.Reader, out io.Writer, fixes <-chan nmea.Fix, filename string, numFixes int) error {
+	if numFixes < 1 {
+		numFixes = 1
+	}
+
+	scanner := bufio.NewScanner(in)
+	lastCode := ""
+
+	for {
+		fmt.Fprint(out, "Code (empty repeats last code, q to quit): ")
+		if !scanner.Scan() {
+			return scanner.Err()
+		}
+
+		code := strings.TrimSpace(scanner.Text())
+		switch code {
+		case "q":
+			return nil
+		case "":
+			code = lastCode
+		}
+
+		if code == "" {
+			continue
+		}
+
+		// The code is stored in the first field the import reads codes from
+		codeField := "cmt"
+		if len(cfg.CodeFields) > 0 {
+			codeField = cfg.CodeFields[0]
+		}
+
+		check := gpx.Waypoint{}
+		setWaypointField(&check, codeField, code)
+		if _, err := parseWaypoint(check); err != nil {
+			fmt.Fprintf(out, "Invalid code %q: %s\n", code, err)
+			continue
+		}
+
+		wp, err := averageFixes(fixes, numFixes)
+		if err != nil {
+			return err
+		}
+		setWaypointField(&wp, codeField, code)
+
+		if wp.Name, err = appendWaypoint(filename, wp); err != nil {
+			return err
+		}
+
+		fmt.Fprintf(out, "Saved waypoint %s: %s at %.7f,%.7f (HDOP %.1f, %d fixes)\n",
+			wp.Name, code, wp.Latitude, wp.Longitude, wp.HDOP, numFixes)
+		lastCode = code
+	}
+}
+
+// averageFixes takes the next fixes and returns their average position
+func averageFixes(fixes <-chan nmea.Fix, numFixes int) (gpx.Waypoint, error) {
+	wp := gpx.Waypoint{}
+
+	for i := 0; i < numFixes; i++ {
+		fix, ok := <-fixes
+		if !ok {
+			return wp, fmt.Errorf("NMEA source ended after %d of %d fixes", i, numFixes)
+		}
+
+		wp.Latitude += fix.Latitude / float64(numFixes)
+		wp.Longitude += fix.Longitude / float64(numFixes)
+		wp.Elevation += fix.Elevation / float64(numFixes)
+		wp.HDOP += fix.HDOP / float64(numFixes)
+
+		if i == 0 || fix.Satellites < wp.Satellites {
+			wp.Satellites = fix.Satellites
+		}
+		wp.Time = fix.Time
+	}
+
+	wp.Latitude = roundPrec(wp.Latitude, 7)
+	wp.Longitude = roundPrec(wp.Longitude, 7)
+	wp.HDOP = roundPrec(wp.HDOP, 1)
+
+	return wp, nil
+}
+
+// setWaypointField sets the field of the waypoint the hydrant code is
+// read from, see waypointField
+func setWaypointField(wp *gpx.Waypoint, field, value string) {
+	switch field {
+	case "cmt":
+		wp.Comment = value
+	case "name":
+		wp.Name = value
+	case "desc":
+		wp.Description = value
+	case "sym":
+		wp.Symbol = value
+	case "type":
+		wp.Type = value
+	}
+}
+
+// appendWaypoint adds the waypoint to the GPX file, creating the file if
+// it does not exist, and returns the name of the waypoint. Waypoints
+// without name are numbered.
+func appendWaypoint(filename string, wp gpx.Waypoint) (string, error) {
+	data := &gpx.GPX{}
+
+	if f, err := os.Open(filename); err == nil {
+		data, err = gpx.ParseGPXData(f)
+		f.Close()
+		if err != nil {
+			return "", fmt.Errorf("Unable to parse existing GPX file: %s", err)
+		}
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+
+	if wp.Name == "" {
+		wp.Name = fmt.Sprintf("%03d", len(data.Waypoints)+1)
+	}
+	data.Waypoints = append(data.Waypoints, wp)
+
+	tmp, err := os.Create(filename + ".tmp")
+	if err != nil {
+		return "", err
+	}
+
+	if err := gpx.WriteGPXData(tmp, data, fmt.Sprintf("gpxhydrant %s", version)); err != nil {
+		tmp.Close()
+		return "", err
+	}
+
+	if err := tmp.Close(); err != nil {
+		return "", err
+	}
+
+	return wp.Name, os.Rename(filename+".tmp", filename)
+}
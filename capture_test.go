@@ -0,0 +1,115 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+	"github.com/Luzifer/gpxhydrant/nmea"
+)
+
+const captureTestLog = `$GPRMC,123400.00,A,5335.0711,N,00943.6793,E,0.0,0.0,060516,,,A*5C
+$GPGGA,123400.00,5335.0710,N,00943.6793,E,1,08,0.9,23.4,M,46.9,M,,*54
+$GPRMC,123401.00,A,5335.0711,N,00943.6793,E,0.0,0.0,060516,,,A*5D
+$GPGGA,123401.00,5335.0711,N,00943.6793,E,1,08,1.0,23.4,M,46.9,M,,*5C
+$GPRMC,123402.00,A,5335.0711,N,00943.6793,E,0.0,0.0,060516,,,A*5E
+$GPGGA,123402.00,5335.0712,N,00943.6793,E,1,08,1.1,23.4,M,46.9,M,,*5D
+$GPRMC,123403.00,A,5335.0711,N,00943.6793,E,0.0,0.0,060516,,,A*5F
+$GPGGA,123403.00,5335.0713,N,00943.6793,E,1,08,1.2,23.4,M,46.9,M,,*5E
+`
+
+const captureTestGPX = `<?xml version="1.0"?>
+<gpx xmlns="http://www.topografix.com/GPX/1/1">
+<wpt lat="53.58" lon="9.72"><name>001</name><cmt>SU100</cmt></wpt>
+<trk><name>walk</name><trkseg>
+<trkpt lat="53.5845" lon="9.7279"><time>2016-05-06T12:30:00Z</time></trkpt>
+<trkpt lat="53.5846" lon="9.7280"><time>2016-05-06T12:31:00Z</time></trkpt>
+</trkseg></trk>
+</gpx>
+`
+
+func captureReplay(t *testing.T, input string) (*gpx.GPX, string) {
+	dir, err := ioutil.TempDir("", "gpxhydrant")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "survey.gpx")
+	if err := ioutil.WriteFile(filename, []byte(captureTestGPX), 0644); err != nil {
+		t.Fatalf("Unable to write GPX file: %s", err)
+	}
+
+	fixes := streamFixes(nmea.NewReader(strings.NewReader(captureTestLog)), false)
+	out := new(bytes.Buffer)
+	if err := captureWaypoints(strings.NewReader(input), out, fixes, filename, 2); err != nil {
+		t.Fatalf("Capture failed: %s", err)
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		t.Fatalf("Unable to open GPX file: %s", err)
+	}
+	defer f.Close()
+
+	data, err := gpx.ParseGPXData(f)
+	if err != nil {
+		t.Fatalf("Unable to parse GPX file: %s", err)
+	}
+
+	return data, out.String()
+}
+
+func TestCaptureReplay(t *testing.T) {
+	data, out := captureReplay(t, "SU125\nfoo\n\nq\n")
+
+	if !strings.Contains(out, `Invalid code "foo"`) {
+		t.Errorf("Expected invalid code to be reported, got %q", out)
+	}
+
+	if len(data.Waypoints) != 3 {
+		t.Fatalf("Expected 3 waypoints, got %d", len(data.Waypoints))
+	}
+
+	for i, exp := range []struct {
+		Name, Comment string
+		Latitude      float64
+		HDOP          float64
+	}{
+		{"001", "SU100", 53.58, 0},
+		{"002", "SU125", 53.5845175, 0.9},
+		{"003", "SU125", 53.5845208, 1.1},
+	} {
+		wp := data.Waypoints[i]
+		if wp.Name != exp.Name || wp.Comment != exp.Comment || wp.Latitude != exp.Latitude || wp.HDOP != exp.HDOP {
+			t.Errorf("Waypoint %d: expected %+v, got %s/%s %.7f (HDOP %.1f)", i, exp, wp.Name, wp.Comment, wp.Latitude, wp.HDOP)
+		}
+	}
+
+	if exp := time.Date(2016, 5, 6, 12, 34, 3, 0, time.UTC); !data.Waypoints[2].Time.Equal(exp) {
+		t.Errorf("Expected time %s of the last fix, got %s", exp, data.Waypoints[2].Time)
+	}
+
+	if len(data.Tracks) != 1 || len(data.Tracks[0].Segments) != 1 || len(data.Tracks[0].Segments[0].Points) != 2 {
+		t.Errorf("Expected the track of the existing file to be kept, got %#v", data.Tracks)
+	}
+}
+
+func TestCaptureCodeField(t *testing.T) {
+	defer func(fields []string) { cfg.CodeFields = fields }(cfg.CodeFields)
+	cfg.CodeFields = []string{"desc"}
+
+	data, _ := captureReplay(t, "SU125\nq\n")
+
+	if len(data.Waypoints) != 2 {
+		t.Fatalf("Expected 2 waypoints, got %d", len(data.Waypoints))
+	}
+	if wp := data.Waypoints[1]; wp.Description != "SU125" || wp.Comment != "" {
+		t.Errorf("Expected the code in the description, got desc=%q cmt=%q", wp.Description, wp.Comment)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"math"
+	"strings"
+
+	"github.com/Luzifer/go_helpers/position"
+	log "github.com/Sirupsen/logrus"
+)
+
+// clusterHydrants groups hydrants recorded within the given range (in
+// meters) of each other and having compatible codes, and merges every
+// group into one hydrant. Conflicting codes inside the range are logged
+// and kept as separate hydrants.
+func clusterHydrants(hydrants []*hydrant, clusterRange float64) []*hydrant {
+	if clusterRange <= 0 {
+		return hydrants
+	}
+
+	clusters := [][]*hydrant{}
+
+	for _, h := range hydrants {
+		var (
+			bestCluster = -1
+			bestDist    = math.MaxFloat64
+		)
+
+		for i, c := range clusters {
+			dist, compatible := clusterDistance(c, h)
+			if dist > clusterRange {
+				continue
+			}
+
+			if !compatible {
+				log.Warnf("Conflicting codes for waypoints %s and %s (%.1fm apart), keeping them separate",
+					clusterNames(c), h.Name, dist)
+				continue
+			}
+
+			if dist < bestDist {
+				bestCluster, bestDist = i, dist
+			}
+		}
+
+		if bestCluster < 0 {
+			clusters = append(clusters, []*hydrant{h})
+			continue
+		}
+
+		clusters[bestCluster] = append(clusters[bestCluster], h)
+	}
+
+	out := []*hydrant{}
+	for _, c := range clusters {
+		m := mergeHydrants(c)
+		if len(c) > 1 {
+			log.Infof("Merged waypoints %s into one hydrant", m.Name)
+		}
+		out = append(out, m)
+	}
+
+	return out
+}
+
+// clusterDistance returns the distance in meters to the nearest member
+// of the cluster and whether the hydrant is compatible to all members
+func clusterDistance(cluster []*hydrant, h *hydrant) (float64, bool) {
+	var (
+		compatible = true
+		dist       = math.MaxFloat64
+	)
+
+	for _, m := range cluster {
+		dist = math.Min(dist, position.Haversine(h.Longitude, h.Latitude, m.Longitude, m.Latitude)*1000)
+		compatible = compatible && m.CompatibleWith(h)
+	}
+
+	return dist, compatible
+}
+
+func clusterNames(cluster []*hydrant) string {
+	names := []string{}
+	for _, h := range cluster {
+		names = append(names, h.Name)
+	}
+	return strings.Join(names, "+")
+}
+
+// mergeHydrants averages the position of all hydrants in the cluster.
+// If every hydrant has a known HDOP the positions are weighted by the
+// inverse square of the HDOP, otherwise all positions have equal weight.
+func mergeHydrants(cluster []*hydrant) *hydrant {
+	if len(cluster) == 1 {
+		return cluster[0]
+	}
+
+	weighted := true
+	for _, h := range cluster {
+		weighted = weighted && h.HDOP > 0
+	}
+
+	out := *cluster[0]
+	out.Name = clusterNames(cluster)
+	out.Sources = nil
+
+	var lat, lon, weightSum float64
+	for _, h := range cluster {
+		weight := 1.0
+		if weighted {
+			weight = 1 / (h.HDOP * h.HDOP)
+		}
+
+		lat += h.Latitude * weight
+		lon += h.Longitude * weight
+		weightSum += weight
+
+		if out.Diameter == 0 {
+			out.Diameter = h.Diameter
+		}
+		if h.SurveyDate.After(out.SurveyDate) {
+			out.SurveyDate = h.SurveyDate
+		}
+		out.Sources = append(out.Sources, h.Sources...)
+	}
+
+	out.Latitude = roundPrec(lat/weightSum, 7)
+	out.Longitude = roundPrec(lon/weightSum, 7)
+
+	out.HDOP = 0
+	if weighted {
+		out.HDOP = 1 / math.Sqrt(weightSum)
+	}
+
+	return &out
+}
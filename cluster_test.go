@@ -0,0 +1,96 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestClusterHydrants(t *testing.T) {
+	// 0.00001° latitude is about 1.1m
+	at := func(name string, latOffset, hdop float64) *hydrant {
+		return &hydrant{Name: name, Latitude: 53.6 + latOffset, Longitude: 9.7, Type: "underground", Diameter: 100, HDOP: hdop}
+	}
+
+	type result struct {
+		Name string
+		Lat  float64
+		HDOP float64
+	}
+
+	for _, c := range []struct {
+		Name     string
+		Hydrants []*hydrant
+		Range    float64
+		Expected []result
+	}{
+		{
+			Name:     "clustering disabled",
+			Hydrants: []*hydrant{at("1", 0, 0), at("2", 0.00001, 0)},
+			Range:    0,
+			Expected: []result{{"1", 53.6, 0}, {"2", 53.60001, 0}},
+		},
+		{
+			Name:     "out of range",
+			Hydrants: []*hydrant{at("1", 0, 0), at("2", 0.0001, 0)},
+			Range:    5,
+			Expected: []result{{"1", 53.6, 0}, {"2", 53.6001, 0}},
+		},
+		{
+			Name:     "single linkage chain",
+			Hydrants: []*hydrant{at("1", 0, 0), at("2", 0.00003, 0), at("3", 0.00006, 0)},
+			Range:    4,
+			Expected: []result{{"1+2+3", 53.60003, 0}},
+		},
+		{
+			Name:     "weighted by HDOP",
+			Hydrants: []*hydrant{at("1", 0, 1), at("2", 0.00005, 2)},
+			Range:    10,
+			Expected: []result{{"1+2", 53.60001, 1 / math.Sqrt(1.25)}},
+		},
+		{
+			Name:     "HDOP missing on one waypoint",
+			Hydrants: []*hydrant{at("1", 0, 1), at("2", 0.00004, 0)},
+			Range:    10,
+			Expected: []result{{"1+2", 53.60002, 0}},
+		},
+		{
+			Name: "conflicting codes",
+			Hydrants: []*hydrant{
+				at("1", 0, 0),
+				{Name: "2", Latitude: 53.60001, Longitude: 9.7, Type: "pillar", Diameter: 100},
+				{Name: "3", Latitude: 53.60002, Longitude: 9.7, Type: "underground", Diameter: 150},
+				at("4", 0.00003, 0),
+			},
+			Range:    10,
+			Expected: []result{{"1+4", 53.600015, 0}, {"2", 53.60001, 0}, {"3", 53.60002, 0}},
+		},
+	} {
+		got := clusterHydrants(c.Hydrants, c.Range)
+		if len(got) != len(c.Expected) {
+			t.Errorf("%s: expected %d hydrants, got %d", c.Name, len(c.Expected), len(got))
+			continue
+		}
+
+		for i, e := range c.Expected {
+			h := got[i]
+			if h.Name != e.Name || math.Abs(h.Latitude-e.Lat) > 1e-7 || math.Abs(h.HDOP-e.HDOP) > 1e-6 {
+				t.Errorf("%s: expected hydrant %d to be %s at %.7f (HDOP %.2f), got %s at %.7f (HDOP %.2f)",
+					c.Name, i, e.Name, e.Lat, e.HDOP, h.Name, h.Latitude, h.HDOP)
+			}
+		}
+	}
+}
+
+func TestMergeHydrants(t *testing.T) {
+	merged := mergeHydrants([]*hydrant{
+		{Name: "1", Latitude: 53.6, Longitude: 9.7, Type: "underground"},
+		{Name: "2", Latitude: 53.6, Longitude: 9.7002, Type: "underground", Diameter: 100},
+	})
+
+	if merged.Name != "1+2" || merged.Longitude != 9.7001 {
+		t.Errorf("Expected 1+2 at 9.7001, got %s at %.7f", merged.Name, merged.Longitude)
+	}
+	if merged.Diameter != 100 {
+		t.Errorf("Expected diameter to be taken from the second waypoint, got %d", merged.Diameter)
+	}
+}
@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Luzifer/go_helpers/position"
+	"github.com/Luzifer/gpxhydrant/geojson"
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// coverageStep is the length in meters of the pieces the streets are
+// split into to check their distance to the hydrants
+const coverageStep = 5.0
+
+// coverageHighways are the highway types expected to be covered by
+// hydrants, motorways, service roads and paths are ignored
+var coverageHighways = []string{
+	"trunk", "trunk_link", "primary", "primary_link", "secondary", "secondary_link",
+	"tertiary", "tertiary_link", "unclassified", "residential", "living_street", "pedestrian",
+}
+
+// coverageGap is a continuous part of a street farther than the coverage
+// range from any hydrant
+type coverageGap struct {
+	WayID   int64
+	Street  string
+	Highway string
+	// Points contains the coordinates as longitude, latitude pairs
+	Points [][2]float64
+	Length float64
+	// MaxDistance is the largest distance of the gap to a hydrant
+	MaxDistance float64
+}
+
+// streetCoverage summarizes the coverage of all ways with the same name
+type streetCoverage struct {
+	Street     string
+	Length     float64
+	Uncovered  float64
+	Gaps       int
+	LongestGap float64
+}
+
+func runAnalyzeCoverage() {
+	bds := analyzeBounds()
+	osmClient := newOSMClient()
+
+	// Hydrants outside of the bounds cover the streets at the edges
+	mapData := retrieveMapData(osmClient, bds, math.Max(mapDataBorder, float64(cfg.CoverageRange)))
+	hydrants := hydrantsFromMapData(mapData)
+
+	gaps, streets := findCoverageGaps(mapData, hydrants, bds, float64(cfg.CoverageRange))
+	writeCoverageReport(os.Stdout, streets, len(hydrants))
+
+	if cfg.CoverageFile == "" {
+		return
+	}
+
+	f, err := os.Create(cfg.CoverageFile)
+	if err != nil {
+		log.Fatalf("Unable to create coverage file: %s", err)
+	}
+	defer f.Close()
+
+	if err := geojson.WriteGeoJSONData(f, coverageFeatures(gaps)); err != nil {
+		log.Fatalf("Unable to write coverage file: %s", err)
+	}
+}
+
+// findCoverageGaps splits all streets within the bounds into short pieces
+// and collects the pieces farther than maxRange from the nearest hydrant
+// into gaps
+func findCoverageGaps(mapData *osm.Wrap, hydrants []*hydrant, bds bounds, maxRange float64) ([]*coverageGap, []*streetCoverage) {
+	nodes := map[int64]*osm.Node{}
+	for _, n := range mapData.Nodes {
+		nodes[n.ID] = n
+	}
+
+	var (
+		gaps    = []*coverageGap{}
+		streets = map[string]*streetCoverage{}
+	)
+
+	for _, w := range mapData.Ways {
+		tags := map[string]string{}
+		for _, t := range w.Tags {
+			tags[t.Key] = t.Value
+		}
+		if !containsString(coverageHighways, tags["highway"]) {
+			continue
+		}
+
+		name := tags["name"]
+		if name == "" {
+			name = tags["ref"]
+		}
+		if name == "" {
+			name = fmt.Sprintf("(unnamed %s)", tags["highway"])
+		}
+
+		s, ok := streets[name]
+		if !ok {
+			s = &streetCoverage{Street: name}
+			streets[name] = s
+		}
+
+		var gap *coverageGap
+		closeGap := func() {
+			if gap != nil {
+				gaps = append(gaps, gap)
+				s.Gaps++
+				s.LongestGap = math.Max(s.LongestGap, gap.Length)
+			}
+			gap = nil
+		}
+
+		for i := 1; i < len(w.NodeRefs); i++ {
+			a, aok := nodes[w.NodeRefs[i-1].Ref]
+			b, bok := nodes[w.NodeRefs[i].Ref]
+			if !aok || !bok {
+				closeGap()
+				continue
+			}
+
+			length := position.Haversine(a.Longitude, a.Latitude, b.Longitude, b.Latitude) * 1000
+			pieces := int(math.Ceil(length / coverageStep))
+
+			for k := 0; k < pieces; k++ {
+				f0, f1 := float64(k)/float64(pieces), float64(k+1)/float64(pieces)
+				p0 := [2]float64{a.Longitude + (b.Longitude-a.Longitude)*f0, a.Latitude + (b.Latitude-a.Latitude)*f0}
+				p1 := [2]float64{a.Longitude + (b.Longitude-a.Longitude)*f1, a.Latitude + (b.Latitude-a.Latitude)*f1}
+				midLon, midLat := (p0[0]+p1[0])/2, (p0[1]+p1[1])/2
+
+				if midLat < bds.MinLat || midLat > bds.MaxLat || midLon < bds.MinLon || midLon > bds.MaxLon || !surveyArea.Contains(midLat, midLon) {
+					closeGap()
+					continue
+				}
+
+				pieceLength := length / float64(pieces)
+				s.Length += pieceLength
+
+				dist := nearestHydrantDistance(midLat, midLon, hydrants)
+				if dist <= maxRange {
+					closeGap()
+					continue
+				}
+
+				s.Uncovered += pieceLength
+				if gap == nil {
+					gap = &coverageGap{WayID: w.ID, Street: name, Highway: tags["highway"], Points: [][2]float64{p0}}
+				}
+				gap.Points = append(gap.Points, p1)
+				gap.Length += pieceLength
+				gap.MaxDistance = math.Max(gap.MaxDistance, dist)
+			}
+		}
+		closeGap()
+	}
+
+	out := []*streetCoverage{}
+	for _, s := range streets {
+		if s.Length > 0 {
+			out = append(out, s)
+		}
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Uncovered != out[j].Uncovered {
+			return out[i].Uncovered > out[j].Uncovered
+		}
+		return out[i].Street < out[j].Street
+	})
+
+	return gaps, out
+}
+
+// nearestHydrantDistance returns the distance in meters to the nearest
+// hydrant or infinity if there are no hydrants
+func nearestHydrantDistance(lat, lon float64, hydrants []*hydrant) float64 {
+	dist := math.Inf(1)
+	for _, h := range hydrants {
+		dist = math.Min(dist, position.Haversine(lon, lat, h.Longitude, h.Latitude)*1000)
+	}
+	return dist
+}
+
+func writeCoverageReport(out io.Writer, streets []*streetCoverage, numHydrants int) {
+	var length, uncovered float64
+	gaps := 0
+	for _, s := range streets {
+		length += s.Length
+		uncovered += s.Uncovered
+		gaps += s.Gaps
+	}
+
+	fmt.Fprintf(out, "Checked %.0fm of streets against %d hydrants: %.0fm (%.1f%%) in %d gaps are farther than %dm from a hydrant\n\n",
+		length, numHydrants, uncovered, coveragePercent(uncovered, length), gaps, cfg.CoverageRange)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Street\tLength\tUncovered\tShare\tGaps\tLongest gap\t")
+	for _, s := range streets {
+		fmt.Fprintf(tw, "%s\t%.0fm\t%.0fm\t%.1f%%\t%d\t%.0fm\t\n", s.Street, s.Length, s.Uncovered, coveragePercent(s.Uncovered, s.Length), s.Gaps, s.LongestGap)
+	}
+	tw.Flush()
+}
+
+func coveragePercent(part, total float64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return part / total * 100
+}
+
+func coverageFeatures(gaps []*coverageGap) *geojson.FeatureCollection {
+	fc := geojson.NewFeatureCollection()
+	for _, g := range gaps {
+		props := map[string]interface{}{
+			"way_id":       g.WayID,
+			"name":         g.Street,
+			"highway":      g.Highway,
+			"length":       roundPrec(g.Length, 1),
+			"max_distance": nil,
+		}
+		// Without any hydrant in the area the distance is infinite which
+		// can't be represented in JSON
+		if !math.IsInf(g.MaxDistance, 1) {
+			props["max_distance"] = roundPrec(g.MaxDistance, 1)
+		}
+		fc.Features = append(fc.Features, geojson.NewFeature(geojson.NewLineString(g.Points), props))
+	}
+	return fc
+}
@@ -0,0 +1,214 @@
+// Package csvimport reads hydrant lists from CSV files using a column
+// mapping and converts them into GPX waypoints carrying explicit tags.
+package csvimport
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+	"github.com/Luzifer/gpxhydrant/proj"
+	yaml "gopkg.in/yaml.v2"
+)
+
+var diameterPattern = regexp.MustCompile(`[0-9]+`)
+
+// Mapping describes how to read the columns of a CSV file
+type Mapping struct {
+	// Source describes the dataset and is set as "source" tag
+	Source string `yaml:"source"`
+	// CRS contains the coordinate reference system of the coordinates
+	// (wgs84, utm32, gk3, EPSG:25832, ...)
+	CRS string `yaml:"crs"`
+	// Delimiter separates the columns, defaults to ","
+	Delimiter string `yaml:"delimiter"`
+
+	// Columns maps fields to column names in the header line, compared
+	// ignoring case and surrounding whitespace. For WGS84 data the fields
+	// "lat" and "lon" are used, for projected data "x" (easting) and "y"
+	// (northing). Further fields: "name", "diameter", "type", "position",
+	// "pressure", "ref" and "operator".
+	Columns map[string]string `yaml:"columns"`
+	// Values maps raw column values to OSM values per field, for example
+	// type: {UFH: underground, OFH: pillar}
+	Values map[string]map[string]string `yaml:"values"`
+	// Defaults contains values for fields without column or with empty
+	// values in the column
+	Defaults map[string]string `yaml:"defaults"`
+}
+
+// LoadMapping reads the mapping from a YAML file
+func LoadMapping(filename string) (*Mapping, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Mapping{}
+	return m, yaml.Unmarshal(data, m)
+}
+
+var tagFields = map[string]string{
+	"diameter": "fire_hydrant:diameter",
+	"type":     "fire_hydrant:type",
+	"position": "fire_hydrant:position",
+	"pressure": "fire_hydrant:pressure",
+	"ref":      "ref",
+	"operator": "operator",
+}
+
+// ParseCSVData reads all rows of the CSV file and returns them as
+// waypoints with their tags set from the mapped columns
+func ParseCSVData(in io.Reader, m *Mapping) (*gpx.GPX, error) {
+	projection, err := proj.Parse(m.CRS)
+	if err != nil {
+		return nil, err
+	}
+
+	r := csv.NewReader(in)
+	r.TrimLeadingSpace = true
+	if m.Delimiter != "" {
+		r.Comma = []rune(m.Delimiter)[0]
+	}
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, err
+	}
+
+	// Spreadsheet exports often start with a byte order mark
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	columns := map[string]int{}
+	for field, name := range m.Columns {
+		idx := -1
+		for i, h := range header {
+			if strings.EqualFold(strings.TrimSpace(h), strings.TrimSpace(name)) {
+				idx = i
+				break
+			}
+		}
+		if idx < 0 {
+			return nil, fmt.Errorf("Column %q for field %q not found in header", name, field)
+		}
+		columns[field] = idx
+	}
+
+	out := &gpx.GPX{}
+	for line := 2; ; line++ {
+		record, err := r.Read()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		wp, err := m.toWaypoint(projection, columns, record)
+		if err != nil {
+			return nil, fmt.Errorf("Line %d: %s", line, err)
+		}
+
+		if wp.Name == "" {
+			wp.Name = fmt.Sprintf("line %d", line)
+		}
+
+		out.Waypoints = append(out.Waypoints, wp)
+	}
+}
+
+func (m Mapping) value(columns map[string]int, record []string, field string) (string, error) {
+	v := m.Defaults[field]
+	if idx, ok := columns[field]; ok && idx < len(record) && strings.TrimSpace(record[idx]) != "" {
+		v = strings.TrimSpace(record[idx])
+	}
+
+	if values, ok := m.Values[field]; ok && v != "" {
+		mapped, ok := values[v]
+		if !ok {
+			return "", fmt.Errorf("No mapping for %s value %q", field, v)
+		}
+		v = mapped
+	}
+
+	return v, nil
+}
+
+func (m Mapping) coordinate(columns map[string]int, record []string, field string) (float64, error) {
+	v, err := m.value(columns, record, field)
+	if err != nil {
+		return 0, err
+	}
+	if v == "" {
+		return 0, fmt.Errorf("Coordinate %s is missing", field)
+	}
+
+	// Support decimal commas often found in german datasets
+	c, err := strconv.ParseFloat(strings.Replace(v, ",", ".", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid coordinate %s %q", field, v)
+	}
+
+	return c, nil
+}
+
+func (m Mapping) toWaypoint(projection *proj.TransverseMercator, columns map[string]int, record []string) (gpx.Waypoint, error) {
+	wp := gpx.Waypoint{Tags: map[string]string{"emergency": "fire_hydrant"}}
+
+	var err error
+	if projection == nil {
+		if wp.Latitude, err = m.coordinate(columns, record, "lat"); err != nil {
+			return wp, err
+		}
+		if wp.Longitude, err = m.coordinate(columns, record, "lon"); err != nil {
+			return wp, err
+		}
+	} else {
+		x, err := m.coordinate(columns, record, "x")
+		if err != nil {
+			return wp, err
+		}
+		y, err := m.coordinate(columns, record, "y")
+		if err != nil {
+			return wp, err
+		}
+		if wp.Latitude, wp.Longitude, err = projection.ToWGS84(x, y); err != nil {
+			return wp, err
+		}
+	}
+
+	if wp.Name, err = m.value(columns, record, "name"); err != nil {
+		return wp, err
+	}
+
+	for field, tag := range tagFields {
+		v, err := m.value(columns, record, field)
+		if err != nil {
+			return wp, err
+		}
+
+		if field == "diameter" && v != "" {
+			// Strip prefixes like "DN" from the diameter
+			v = diameterPattern.FindString(v)
+		}
+
+		if v != "" {
+			wp.Tags[tag] = v
+		}
+	}
+
+	if wp.Name == "" {
+		wp.Name = wp.Tags["ref"]
+	}
+
+	if m.Source != "" {
+		wp.Tags["source"] = m.Source
+	}
+
+	return wp, nil
+}
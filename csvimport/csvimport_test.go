@@ -0,0 +1,168 @@
+package csvimport
+
+import (
+	"math"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCSVDataHeader(t *testing.T) {
+	m := &Mapping{
+		CRS:       "wgs84",
+		Delimiter: ";",
+		Columns:   map[string]string{"lat": "Breite", "lon": "Länge", "ref": "Nummer"},
+	}
+
+	for _, c := range []struct {
+		Name   string
+		Header string
+		Err    bool
+	}{
+		{"exact names", "Nummer;Breite;Länge", false},
+		{"different order", "Länge;Nummer;Breite", false},
+		{"different case", "NUMMER;breite;LÄNGE", false},
+		{"surrounding spaces", " Nummer ; Breite;Länge ", false},
+		{"byte order mark", "\ufeffBreite;Länge;Nummer", false},
+		{"additional columns", "Art;Breite;Länge;Nummer;Bemerkung", false},
+		{"missing column", "Breite;Länge", true},
+		{"empty file", "", true},
+	} {
+		data := c.Header
+		if data != "" {
+			// Values in the order of the mapped columns in the header
+			values := []string{}
+			for _, h := range strings.Split(strings.TrimPrefix(c.Header, "\ufeff"), ";") {
+				values = append(values, map[string]string{"nummer": "H1", "breite": "53.6", "länge": "9.7"}[strings.ToLower(strings.TrimSpace(h))])
+			}
+			data = c.Header + "\n" + strings.Join(values, ";") + "\n"
+		}
+
+		out, err := ParseCSVData(strings.NewReader(data), m)
+		if c.Err {
+			if err == nil {
+				t.Errorf("%s: expected error", c.Name)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.Name, err)
+			continue
+		}
+
+		if len(out.Waypoints) != 1 {
+			t.Errorf("%s: expected one waypoint, got %d", c.Name, len(out.Waypoints))
+			continue
+		}
+		wp := out.Waypoints[0]
+		if wp.Latitude != 53.6 || wp.Longitude != 9.7 || wp.Tags["ref"] != "H1" {
+			t.Errorf("%s: unexpected waypoint %#v", c.Name, wp)
+		}
+	}
+}
+
+func TestParseCSVDataCoordinates(t *testing.T) {
+	m := &Mapping{CRS: "wgs84", Columns: map[string]string{"lat": "lat", "lon": "lon"}}
+
+	for _, c := range []struct {
+		Name     string
+		Row      string
+		Lat, Lon float64
+		Err      string
+	}{
+		{Name: "valid", Row: "53.6,9.7", Lat: 53.6, Lon: 9.7},
+		{Name: "decimal comma", Row: `"53,6","9,7"`, Lat: 53.6, Lon: 9.7},
+		{Name: "missing latitude", Row: ",9.7", Err: "Line 2: Coordinate lat is missing"},
+		{Name: "missing longitude", Row: "53.6,  ", Err: "Line 2: Coordinate lon is missing"},
+		{Name: "short row", Row: "53.6", Err: "wrong number of fields"},
+		{Name: "invalid latitude", Row: "north,9.7", Err: `Line 2: Invalid coordinate lat "north"`},
+		{Name: "invalid longitude", Row: "53.6,9.7.1", Err: `Line 2: Invalid coordinate lon "9.7.1"`},
+	} {
+		out, err := ParseCSVData(strings.NewReader("lat,lon\n"+c.Row+"\n"), m)
+		if c.Err != "" {
+			if err == nil || !strings.Contains(err.Error(), c.Err) {
+				t.Errorf("%s: expected error %q, got %v", c.Name, c.Err, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.Name, err)
+			continue
+		}
+
+		wp := out.Waypoints[0]
+		if wp.Latitude != c.Lat || wp.Longitude != c.Lon {
+			t.Errorf("%s: expected %f,%f, got %f,%f", c.Name, c.Lat, c.Lon, wp.Latitude, wp.Longitude)
+		}
+	}
+}
+
+func TestParseCSVDataMapping(t *testing.T) {
+	m := &Mapping{
+		Source:    "Hydrantenliste 2018",
+		CRS:       "utm32",
+		Delimiter: ";",
+		Columns: map[string]string{
+			"x":        "Rechtswert",
+			"y":        "Hochwert",
+			"ref":      "Nummer",
+			"diameter": "Nennweite",
+			"type":     "Art",
+		},
+		Values: map[string]map[string]string{
+			"type": {"UFH": "underground", "OFH": "pillar"},
+		},
+		Defaults: map[string]string{"operator": "Stadtwerke Wedel", "type": "UFH"},
+	}
+
+	data := "Rechtswert;Hochwert;Nummer;Nennweite;Art\n" +
+		"32546323,086;5939246,729;H1;DN 100;OFH\n" +
+		"546323.086;5939246.729;;80;\n"
+
+	out, err := ParseCSVData(strings.NewReader(data), m)
+	if err != nil {
+		t.Fatalf("Unable to parse CSV: %s", err)
+	}
+
+	if len(out.Waypoints) != 2 {
+		t.Fatalf("Expected two waypoints, got %d", len(out.Waypoints))
+	}
+
+	for i, c := range []struct {
+		Name string
+		Tags map[string]string
+	}{
+		{"H1", map[string]string{
+			"emergency":             "fire_hydrant",
+			"fire_hydrant:diameter": "100",
+			"fire_hydrant:type":     "pillar",
+			"operator":              "Stadtwerke Wedel",
+			"ref":                   "H1",
+			"source":                "Hydrantenliste 2018",
+		}},
+		{"line 3", map[string]string{
+			"emergency":             "fire_hydrant",
+			"fire_hydrant:diameter": "80",
+			"fire_hydrant:type":     "underground",
+			"operator":              "Stadtwerke Wedel",
+			"source":                "Hydrantenliste 2018",
+		}},
+	} {
+		wp := out.Waypoints[i]
+		if wp.Name != c.Name {
+			t.Errorf("Expected waypoint %d to be named %q, got %q", i, c.Name, wp.Name)
+		}
+		if !reflect.DeepEqual(wp.Tags, c.Tags) {
+			t.Errorf("Expected tags of waypoint %d to be %v, got %v", i, c.Tags, wp.Tags)
+		}
+		if math.Abs(wp.Latitude-53.6) > 0.00001 || math.Abs(wp.Longitude-9.7) > 0.00001 {
+			t.Errorf("Expected waypoint %d at 53.6,9.7, got %f,%f", i, wp.Latitude, wp.Longitude)
+		}
+	}
+
+	// Values without mapping are rejected
+	_, err = ParseCSVData(strings.NewReader("Rechtswert;Hochwert;Nummer;Nennweite;Art\n546323;5939246;H2;100;WFH\n"), m)
+	if err == nil || !strings.Contains(err.Error(), `No mapping for type value "WFH"`) {
+		t.Errorf("Expected error for unmapped type, got %v", err)
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	// Ordered versions of the hydrantPositions / hydrantTypes keys to
+	// get stable suggestions
+	suggestPositions = []string{"S", "P", "L", "G"}
+	suggestTypes     = []string{"U", "O", "W", "P"}
+	suggestDiameters = []string{"?", "50", "65", "80", "100", "125", "150", "200", "250", "300"}
+
+	suggestDigitFixes = strings.NewReplacer("O", "0", "I", "1", "L", "1")
+	suggestDigits     = regexp.MustCompile(`[0-9OIL]{2,3}$`)
+)
+
+type skippedWaypoint struct {
+	Waypoint   gpx.Waypoint
+	Reason     string
+	Suggestion string
+}
+
+func newSkippedWaypoint(wp gpx.Waypoint, err error) skippedWaypoint {
+	out := skippedWaypoint{Waypoint: wp, Reason: err.Error()}
+
+	if err == errWrongGPXComment {
+		text := []string{}
+		for _, field := range cfg.CodeFields {
+			text = append(text, waypointField(wp, field))
+		}
+
+		if strings.TrimSpace(strings.Join(text, "")) == "" {
+			out.Reason = fmt.Sprintf("waypoint has no content in %s", strings.Join(cfg.CodeFields, ", "))
+		} else {
+			out.Reason = fmt.Sprintf("%s does not contain a valid hydrant code", strings.Join(cfg.CodeFields, ", "))
+		}
+		out.Suggestion = suggestCode(strings.Join(text, "\n"))
+	}
+
+	return out
+}
+
+// checkStrict returns an error if waypoints were skipped while strict
+// mode is enabled
+func checkStrict(skipped []skippedWaypoint) error {
+	if cfg.Strict && len(skipped) > 0 {
+		return fmt.Errorf("%d waypoints were skipped and strict mode is enabled", len(skipped))
+	}
+	return nil
+}
+
+// logSkippedWaypoints prints every skipped waypoint and a summary line
+func logSkippedWaypoints(skipped []skippedWaypoint, numHydrants int) {
+	for _, s := range skipped {
+		fields := log.Fields{
+			"name":    s.Waypoint.Name,
+			"lat":     s.Waypoint.Latitude,
+			"lon":     s.Waypoint.Longitude,
+			"comment": strings.Replace(strings.TrimSpace(s.Waypoint.Comment), "\n", " / ", -1),
+		}
+		if s.Suggestion != "" {
+			fields["suggestion"] = s.Suggestion
+		}
+
+		log.WithFields(fields).Warnf("Skipped waypoint %s: %s", s.Waypoint.Name, s.Reason)
+	}
+
+	log.Infof("Read %d waypoints: %d converted into hydrants, %d skipped", numHydrants+len(skipped), numHydrants, len(skipped))
+}
+
+// suggestCode tries to find the valid hydrant code nearest to one of the
+// words in the comment. Only codes differing in one character (two
+// characters for words longer than four characters) are suggested.
+func suggestCode(comment string) string {
+	var (
+		best     string
+		bestDist = 3
+	)
+
+	for _, word := range strings.Fields(strings.ToUpper(comment)) {
+		if len(word) < 3 || len(word) > 6 || !strings.ContainsAny(word, "ABCDEFGHIJKLMNOPQRSTUVWXYZ") {
+			continue
+		}
+
+		maxDist := 2
+		if len(word) <= 4 {
+			maxDist = 1
+		}
+
+		for _, candidate := range codeCandidates(word) {
+			if d := levenshtein(word, candidate); d <= maxDist && d < bestDist {
+				best, bestDist = candidate, d
+			}
+		}
+	}
+
+	return best
+}
+
+func codeCandidates(word string) []string {
+	diameters := suggestDiameters
+	if d := suggestDigits.FindString(word); d != "" && d[0] != '0' {
+		diameters = append([]string{suggestDigitFixes.Replace(d)}, diameters...)
+	}
+
+	out := []string{}
+	for _, p := range suggestPositions {
+		for _, t := range suggestTypes {
+			for _, d := range diameters {
+				out = append(out, p+t+d)
+			}
+		}
+	}
+
+	return out
+}
+
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(a); i++ {
+		cur := make([]int, len(b)+1)
+		cur[0] = i
+
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = minInt(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+
+		prev = cur
+	}
+
+	return prev[len(b)]
+}
+
+func minInt(values ...int) int {
+	out := values[0]
+	for _, v := range values[1:] {
+		if v < out {
+			out = v
+		}
+	}
+	return out
+}
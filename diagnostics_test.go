@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+)
+
+func TestSuggestCode(t *testing.T) {
+	for _, c := range []struct {
+		Comment    string
+		Suggestion string
+	}{
+		{"SX100", "SU100"},
+		{"XU100", "SU100"},
+		{"US100", "SU100"},
+		{"SUU100", "SU100"},
+		{"SU1000", "SU100"},
+		{"S100", "SU100"},
+		{"SU1OO", "SU100"},
+		{"SUI00", "SU100"},
+		{"po8o", "PO80"},
+		{"Hydrant SY80 here", "SU80"},
+		{"first\nSX125", "SU125"},
+		// Two changes are allowed for longer words, three are too far away
+		{"XX100", "SU100"},
+		{"XYZ100", ""},
+		{"ABCDEF", ""},
+		// Not looking like a code at all
+		{"", ""},
+		{"100", ""},
+		{"hello world", ""},
+	} {
+		if got := suggestCode(c.Comment); got != c.Suggestion {
+			t.Errorf("%q: expected suggestion %q, got %q", c.Comment, c.Suggestion, got)
+		}
+	}
+}
+
+func TestLevenshtein(t *testing.T) {
+	for _, c := range []struct {
+		A, B     string
+		Distance int
+	}{
+		{"", "", 0},
+		{"SU100", "SU100", 0},
+		{"", "SU100", 5},
+		{"SX100", "SU100", 1},
+		{"SU10", "SU100", 1},
+		{"US100", "SU100", 2},
+		{"kitten", "sitting", 3},
+	} {
+		if got := levenshtein(c.A, c.B); got != c.Distance {
+			t.Errorf("%q / %q: expected distance %d, got %d", c.A, c.B, c.Distance, got)
+		}
+	}
+}
+
+func TestNewSkippedWaypoint(t *testing.T) {
+	for _, c := range []struct {
+		Comment    string
+		Err        error
+		Reason     string
+		Suggestion string
+	}{
+		{"", errWrongGPXComment, "waypoint has no content in cmt", ""},
+		{"SX100", errWrongGPXComment, "cmt does not contain a valid hydrant code", "SU100"},
+		{"SU100", errOutsideArea, "waypoint is outside of the area", ""},
+	} {
+		s := newSkippedWaypoint(gpx.Waypoint{Name: "001", Comment: c.Comment}, c.Err)
+		if s.Reason != c.Reason || s.Suggestion != c.Suggestion {
+			t.Errorf("%q: expected %q with suggestion %q, got %q with suggestion %q", c.Comment, c.Reason, c.Suggestion, s.Reason, s.Suggestion)
+		}
+	}
+}
+
+func TestCheckStrict(t *testing.T) {
+	defer func(strict bool) { cfg.Strict = strict }(cfg.Strict)
+
+	skipped := []skippedWaypoint{newSkippedWaypoint(gpx.Waypoint{Name: "001"}, errors.New("broken"))}
+
+	for _, c := range []struct {
+		Strict  bool
+		Skipped []skippedWaypoint
+		Err     bool
+	}{
+		{false, nil, false},
+		{false, skipped, false},
+		{true, nil, false},
+		{true, skipped, true},
+	} {
+		cfg.Strict = c.Strict
+		if err := checkStrict(c.Skipped); (err != nil) != c.Err {
+			t.Errorf("strict=%v, %d skipped: expected error %v, got %v", c.Strict, len(c.Skipped), c.Err, err)
+		}
+	}
+}
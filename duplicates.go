@@ -0,0 +1,285 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Luzifer/go_helpers/position"
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// duplicateCluster is a group of OSM hydrants closer to each other than
+// the duplicate range
+type duplicateCluster struct {
+	// Hydrants are sorted by ID, the first one is the oldest node
+	Hydrants []*hydrant
+	// Distance is the largest distance between two members in meters
+	Distance float64
+	// Merged is the oldest node with the tags of all other nodes
+	Merged *hydrant
+	// Conflicts lists the keys having different values in the nodes
+	Conflicts []string
+}
+
+func runAnalyze(args []string) {
+	if len(args) == 0 {
+		log.Fatalf("analyze requires a sub-command (coverage, duplicates, unconfirmed)")
+	}
+
+	switch args[0] {
+	case "coverage":
+		runAnalyzeCoverage()
+	case "duplicates":
+		runAnalyzeDuplicates()
+	case "unconfirmed":
+		runAnalyzeUnconfirmed()
+	default:
+		log.Fatalf("Unknown analyze command %q", args[0])
+	}
+}
+
+func runAnalyzeDuplicates() {
+	bds := analyzeBounds()
+	osmClient := newOSMClient()
+
+	clusters := findDuplicates(surveyArea.Hydrants(getHydrantsFromOSM(osmClient, bds)), float64(cfg.DuplicateRange))
+	writeDuplicateReport(os.Stdout, clusters)
+
+	if cfg.OsmChangeFile == "" {
+		return
+	}
+
+	f, err := os.Create(cfg.OsmChangeFile)
+	if err != nil {
+		log.Fatalf("Unable to create osmChange file: %s", err)
+	}
+	defer f.Close()
+
+	if err := duplicatesOsmChange(clusters).Write(f); err != nil {
+		log.Fatalf("Unable to write osmChange file: %s", err)
+	}
+}
+
+// analyzeBounds returns the area to analyze from the bbox parameter or
+// the hydrants in the gpx-file
+func analyzeBounds() bounds {
+	if cfg.BBox == "" && cfg.GPXFile == "" && surveyArea != nil {
+		return surveyArea.Bounds()
+	}
+
+	if cfg.BBox == "" {
+		requireGPXFile()
+		_, _, bds := hydrantsFromGPXFile()
+		return bds
+	}
+
+	parts := strings.Split(cfg.BBox, ",")
+	if len(parts) != 4 {
+		log.Fatalf("bbox needs to be in format min_lon,min_lat,max_lon,max_lat")
+	}
+
+	values := []float64{}
+	for _, p := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil {
+			log.Fatalf("Invalid value %q in bbox: %s", p, err)
+		}
+		values = append(values, v)
+	}
+
+	return bounds{MinLon: values[0], MinLat: values[1], MaxLon: values[2], MaxLat: values[3]}
+}
+
+// findDuplicates groups all hydrants within the given range (in meters)
+// of each other
+func findDuplicates(hydrants []*hydrant, duplicateRange float64) []*duplicateCluster {
+	var (
+		group = make([]int, len(hydrants))
+		out   = []*duplicateCluster{}
+	)
+
+	for i := range group {
+		group[i] = i
+	}
+
+	// Single linkage: every pair within range ends up in the same group
+	for i := range hydrants {
+		for j := i + 1; j < len(hydrants); j++ {
+			if hydrantDistance(hydrants[i], hydrants[j]) > duplicateRange {
+				continue
+			}
+
+			from, to := group[j], group[i]
+			for k := range group {
+				if group[k] == from {
+					group[k] = to
+				}
+			}
+		}
+	}
+
+	members := map[int][]*hydrant{}
+	for i, h := range hydrants {
+		members[group[i]] = append(members[group[i]], h)
+	}
+
+	for _, m := range members {
+		if len(m) < 2 {
+			continue
+		}
+
+		sort.Slice(m, func(i, j int) bool { return m[i].ID < m[j].ID })
+		c := &duplicateCluster{Hydrants: m}
+
+		for i := range m {
+			for j := i + 1; j < len(m); j++ {
+				c.Distance = math.Max(c.Distance, hydrantDistance(m[i], m[j]))
+			}
+		}
+
+		c.Merged, c.Conflicts = mergeDuplicates(m)
+		out = append(out, c)
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Hydrants[0].ID < out[j].Hydrants[0].ID })
+
+	return out
+}
+
+func hydrantDistance(a, b *hydrant) float64 {
+	return position.Haversine(a.Longitude, a.Latitude, b.Longitude, b.Latitude) * 1000
+}
+
+// mergeDuplicates adds all tags of the newer nodes missing in the oldest
+// node to a copy of it and returns the keys having conflicting values
+func mergeDuplicates(cluster []*hydrant) (*hydrant, []string) {
+	var (
+		tags      = tagMap(cluster[0])
+		conflicts = map[string]string{}
+	)
+
+	for _, h := range cluster[1:] {
+		for k, v := range tagMap(h) {
+			switch tags[k] {
+			case "":
+				tags[k] = v
+			case v:
+			default:
+				conflicts[k] = ""
+			}
+		}
+	}
+
+	merged := &hydrant{
+		ID:        cluster[0].ID,
+		Version:   cluster[0].Version,
+		Latitude:  cluster[0].Latitude,
+		Longitude: cluster[0].Longitude,
+		LastEdit:  cluster[0].LastEdit,
+	}
+	for _, k := range sortedKeys(tags) {
+		// Values were parsed by fromNode before, setTag can not fail
+		merged.setTag(k, tags[k])
+	}
+
+	return merged, sortedKeys(conflicts)
+}
+
+func writeDuplicateReport(out io.Writer, clusters []*duplicateCluster) {
+	fmt.Fprintf(out, "Found %d groups of hydrants within %dm of each other\n", len(clusters), cfg.DuplicateRange)
+
+	for _, c := range clusters {
+		ids := []string{}
+		for _, h := range c.Hydrants {
+			ids = append(ids, strconv.FormatInt(h.ID, 10))
+		}
+
+		fmt.Fprintf(out, "\nNodes %s (%.1fm apart)\n", strings.Join(ids, ", "), c.Distance)
+
+		tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+		fmt.Fprintf(tw, "  \tnode %s\t\n", strings.Join(ids, "\tnode "))
+
+		keys := map[string]string{}
+		tags := []map[string]string{}
+		for _, h := range c.Hydrants {
+			t := tagMap(h)
+			for k := range t {
+				keys[k] = ""
+			}
+			tags = append(tags, t)
+		}
+
+		for _, k := range sortedKeys(keys) {
+			values := []string{}
+			for _, t := range tags {
+				v := t[k]
+				if v == "" {
+					v = "-"
+				}
+				values = append(values, v)
+			}
+
+			marker := ""
+			if containsString(c.Conflicts, k) {
+				marker = "!"
+			}
+
+			fmt.Fprintf(tw, "%s %s\t%s\t\n", marker, k, strings.Join(values, "\t"))
+		}
+		tw.Flush()
+
+		if len(c.Conflicts) > 0 {
+			fmt.Fprintf(out, "Conflicting tags (marked with !), not merged: %s\n", strings.Join(c.Conflicts, ", "))
+			continue
+		}
+		fmt.Fprintf(out, "Merge: keep node %d, delete node %s\n", c.Merged.ID, strings.Join(ids[1:], ", node "))
+	}
+}
+
+// duplicatesOsmChange creates an osmChange moving the tags of all newer
+// nodes to the oldest node of each group without conflicts and deleting
+// the newer nodes
+func duplicatesOsmChange(clusters []*duplicateCluster) *osm.OsmChange {
+	out := osm.NewOsmChange(fmt.Sprintf("gpxhydrant %s", version))
+
+	for _, c := range clusters {
+		if len(c.Conflicts) > 0 {
+			continue
+		}
+
+		if c.Hydrants[0].NeedsUpdate(c.Merged) {
+			out.AddModify(changeNode(c.Merged))
+		}
+
+		for _, h := range c.Hydrants[1:] {
+			out.AddDelete(changeNode(h))
+		}
+	}
+
+	return out
+}
+
+func changeNode(h *hydrant) *osm.Node {
+	n := h.ToNode()
+	if !h.LastEdit.IsZero() {
+		lastEdit := h.LastEdit
+		n.Timestamp = &lastEdit
+	}
+	return n
+}
+
+func containsString(haystack []string, needle string) bool {
+	for _, s := range haystack {
+		if s == needle {
+			return true
+		}
+	}
+	return false
+}
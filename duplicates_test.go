@@ -0,0 +1,94 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestFindDuplicates(t *testing.T) {
+	// 0.00001° latitude is about 1.1m
+	at := func(id int64, latOffset float64) *hydrant {
+		return &hydrant{ID: id, Latitude: 53.6 + latOffset, Longitude: 9.7, Type: "underground"}
+	}
+
+	for _, c := range []struct {
+		Name     string
+		Hydrants []*hydrant
+		Expected [][]int64
+	}{
+		{
+			Name:     "no hydrants",
+			Hydrants: nil,
+			Expected: [][]int64{},
+		},
+		{
+			Name:     "all apart",
+			Hydrants: []*hydrant{at(1, 0), at(2, 0.0001), at(3, 0.0002)},
+			Expected: [][]int64{},
+		},
+		{
+			Name:     "single pair",
+			Hydrants: []*hydrant{at(1, 0), at(2, 0.00002), at(3, 0.0002)},
+			Expected: [][]int64{{1, 2}},
+		},
+		{
+			Name:     "chained within range",
+			Hydrants: []*hydrant{at(3, 0.00008), at(1, 0), at(2, 0.00004)},
+			Expected: [][]int64{{1, 2, 3}},
+		},
+		{
+			Name:     "separate groups",
+			Hydrants: []*hydrant{at(4, 0.00102), at(1, 0), at(3, 0.001), at(2, 0.00001)},
+			Expected: [][]int64{{1, 2}, {3, 4}},
+		},
+	} {
+		got := [][]int64{}
+		for _, cl := range findDuplicates(c.Hydrants, 5) {
+			ids := []int64{}
+			for _, h := range cl.Hydrants {
+				ids = append(ids, h.ID)
+			}
+			got = append(got, ids)
+		}
+
+		if !reflect.DeepEqual(got, c.Expected) {
+			t.Errorf("%s: expected clusters %v, got %v", c.Name, c.Expected, got)
+		}
+	}
+}
+
+func TestFindDuplicatesMerge(t *testing.T) {
+	hydrants := []*hydrant{
+		{ID: 2, Latitude: 53.60002, Longitude: 9.7, Type: "pillar", Diameter: 100, Tags: map[string]string{"colour": "red"}},
+		{ID: 1, Latitude: 53.6, Longitude: 9.7, Type: "underground", Pressure: 4},
+	}
+
+	clusters := findDuplicates(hydrants, 5)
+	if len(clusters) != 1 {
+		t.Fatalf("Expected one cluster, got %d", len(clusters))
+	}
+
+	c := clusters[0]
+	if c.Merged.ID != 1 || c.Merged.Latitude != 53.6 {
+		t.Errorf("Expected merge into the oldest node 1, got node %d at %.5f", c.Merged.ID, c.Merged.Latitude)
+	}
+
+	if c.Distance < 2.1 || c.Distance > 2.3 {
+		t.Errorf("Expected distance of 2.2m, got %.1fm", c.Distance)
+	}
+
+	expected := map[string]string{
+		"emergency":             "fire_hydrant",
+		"fire_hydrant:diameter": "100",
+		"fire_hydrant:pressure": "4",
+		"fire_hydrant:type":     "underground",
+		"colour":                "red",
+	}
+	if tags := tagMap(c.Merged); !reflect.DeepEqual(tags, expected) {
+		t.Errorf("Expected merged tags %v, got %v", expected, tags)
+	}
+
+	if !reflect.DeepEqual(c.Conflicts, []string{"fire_hydrant:type"}) {
+		t.Errorf("Expected conflict on fire_hydrant:type, got %v", c.Conflicts)
+	}
+}
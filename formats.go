@@ -0,0 +1,150 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/Luzifer/gpxhydrant/csvimport"
+	"github.com/Luzifer/gpxhydrant/geojson"
+	"github.com/Luzifer/gpxhydrant/gpx"
+	"github.com/Luzifer/gpxhydrant/kml"
+)
+
+// detectFormat returns the explicitly given format or derives it from
+// the extension of the filename
+func detectFormat(filename, format string) string {
+	if format != "" {
+		return strings.ToLower(format)
+	}
+
+	switch ext := strings.ToLower(path.Ext(filename)); ext {
+	case ".json":
+		return "geojson"
+	default:
+		return strings.TrimPrefix(ext, ".")
+	}
+}
+
+// readWaypoints reads the waypoints from the input file in the given
+// format (gpx, kml, kmz, geojson, csv)
+func readWaypoints(filename, format string) (*gpx.GPX, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	switch detectFormat(filename, format) {
+	case "gpx":
+		return gpx.ParseGPXData(f)
+
+	case "kml":
+		placemarks, err := kml.ParseKMLData(f)
+		if err != nil {
+			return nil, err
+		}
+		return kml.ToWaypoints(placemarks), nil
+
+	case "kmz":
+		stat, err := f.Stat()
+		if err != nil {
+			return nil, err
+		}
+		placemarks, err := kml.ParseKMZData(f, stat.Size())
+		if err != nil {
+			return nil, err
+		}
+		return kml.ToWaypoints(placemarks), nil
+
+	case "geojson":
+		fc, err := geojson.ParseGeoJSONData(f)
+		if err != nil {
+			return nil, err
+		}
+		return geojson.ToWaypoints(fc)
+
+	case "csv":
+		if cfg.CSVMapping == "" {
+			return nil, fmt.Errorf("csv-mapping is required to read CSV files")
+		}
+		mapping, err := csvimport.LoadMapping(cfg.CSVMapping)
+		if err != nil {
+			return nil, fmt.Errorf("Unable to load CSV mapping: %s", err)
+		}
+		return csvimport.ParseCSVData(f, mapping)
+
+	default:
+		return nil, fmt.Errorf("Unsupported input format %q", detectFormat(filename, format))
+	}
+}
+
+// writeHydrants writes the hydrants including all their tags to the
+// output file in the given format (kml, kmz, geojson)
+func writeHydrants(filename, format string, hydrants []*hydrant) error {
+	var write func(io.Writer) error
+
+	// Resolve the format before creating the file to keep existing
+	// exports when the format is not supported
+	switch format = detectFormat(filename, format); format {
+	case "kml", "kmz":
+		placemarks := []kml.Placemark{}
+		for _, h := range hydrants {
+			placemarks = append(placemarks, kml.Placemark{
+				Name:      h.Name,
+				Latitude:  h.Latitude,
+				Longitude: h.Longitude,
+				Data:      hydrantProperties(h),
+			})
+		}
+
+		write = func(w io.Writer) error { return kml.WriteKMLData(w, "gpxhydrant", placemarks) }
+		if format == "kmz" {
+			write = func(w io.Writer) error { return kml.WriteKMZData(w, "gpxhydrant", placemarks) }
+		}
+
+	case "geojson":
+		fc := geojson.NewFeatureCollection()
+		for _, h := range hydrants {
+			props := map[string]interface{}{}
+			for k, v := range hydrantProperties(h) {
+				props[k] = v
+			}
+
+			feature := geojson.NewFeature(geojson.NewPoint(h.Longitude, h.Latitude), props)
+			if h.ID > 0 {
+				feature.ID = fmt.Sprintf("node/%d", h.ID)
+			}
+			fc.Features = append(fc.Features, feature)
+		}
+
+		write = func(w io.Writer) error { return geojson.WriteGeoJSONData(w, fc) }
+
+	default:
+		return fmt.Errorf("Unsupported output format %q", format)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func hydrantProperties(h *hydrant) map[string]string {
+	props := map[string]string{}
+	for _, t := range h.ToNode().Tags {
+		props[t.Key] = t.Value
+	}
+	if _, ok := props["name"]; !ok && h.Name != "" {
+		props["name"] = h.Name
+	}
+	return props
+}
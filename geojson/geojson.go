@@ -0,0 +1,273 @@
+package geojson
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+)
+
+// FeatureCollection represents the top level object of a GeoJSON file
+type FeatureCollection struct {
+	Type     string     `json:"type"`
+	Features []*Feature `json:"features"`
+}
+
+// Feature represents a single GeoJSON feature with its geometry
+type Feature struct {
+	Type       string                 `json:"type"`
+	ID         interface{}            `json:"id,omitempty"`
+	Geometry   *Geometry              `json:"geometry"`
+	Properties map[string]interface{} `json:"properties"`
+}
+
+// Geometry contains the geometry of a feature. The coordinates are kept
+// raw and need to be decoded by the methods matching the geometry type.
+type Geometry struct {
+	Type        string          `json:"type"`
+	Coordinates json.RawMessage `json:"coordinates,omitempty"`
+	Geometries  []*Geometry     `json:"geometries,omitempty"`
+}
+
+// NewFeatureCollection creates an empty feature collection
+func NewFeatureCollection() *FeatureCollection {
+	return &FeatureCollection{Type: "FeatureCollection", Features: []*Feature{}}
+}
+
+// NewFeature creates a feature with the given geometry and properties
+func NewFeature(geometry *Geometry, properties map[string]interface{}) *Feature {
+	if properties == nil {
+		properties = map[string]interface{}{}
+	}
+	return &Feature{Type: "Feature", Geometry: geometry, Properties: properties}
+}
+
+// NewPoint creates a point geometry
+func NewPoint(lon, lat float64) *Geometry {
+	c, _ := json.Marshal([2]float64{lon, lat})
+	return &Geometry{Type: "Point", Coordinates: c}
+}
+
+// NewLineString creates a line string geometry from longitude, latitude
+// pairs
+func NewLineString(coordinates [][2]float64) *Geometry {
+	c, _ := json.Marshal(coordinates)
+	return &Geometry{Type: "LineString", Coordinates: c}
+}
+
+// Point decodes the coordinates of a Point geometry
+func (g Geometry) Point() (lon, lat float64, err error) {
+	if g.Type != "Point" {
+		return 0, 0, fmt.Errorf("Geometry is of type %s, not Point", g.Type)
+	}
+
+	c := []float64{}
+	if err := json.Unmarshal(g.Coordinates, &c); err != nil {
+		return 0, 0, err
+	}
+	if len(c) < 2 {
+		return 0, 0, errors.New("Point needs at least two coordinates")
+	}
+
+	return c[0], c[1], nil
+}
+
+// MultiPoint decodes the coordinates of a MultiPoint geometry
+func (g Geometry) MultiPoint() ([][]float64, error) {
+	if g.Type != "MultiPoint" {
+		return nil, fmt.Errorf("Geometry is of type %s, not MultiPoint", g.Type)
+	}
+
+	c := [][]float64{}
+	return c, json.Unmarshal(g.Coordinates, &c)
+}
+
+// Polygon decodes the rings of a Polygon geometry, the first ring is the
+// outer boundary, all others are holes
+func (g Geometry) Polygon() ([][][]float64, error) {
+	if g.Type != "Polygon" {
+		return nil, fmt.Errorf("Geometry is of type %s, not Polygon", g.Type)
+	}
+
+	c := [][][]float64{}
+	return c, json.Unmarshal(g.Coordinates, &c)
+}
+
+// MultiPolygon decodes the polygons of a MultiPolygon geometry
+func (g Geometry) MultiPolygon() ([][][][]float64, error) {
+	if g.Type != "MultiPolygon" {
+		return nil, fmt.Errorf("Geometry is of type %s, not MultiPolygon", g.Type)
+	}
+
+	c := [][][][]float64{}
+	return c, json.Unmarshal(g.Coordinates, &c)
+}
+
+// ParseGeoJSONData reads a GeoJSON document. Single features and bare
+// geometries are wrapped into a feature collection.
+func ParseGeoJSONData(in io.Reader) (*FeatureCollection, error) {
+	raw := json.RawMessage{}
+	if err := json.NewDecoder(in).Decode(&raw); err != nil {
+		return nil, err
+	}
+
+	head := struct {
+		Type string `json:"type"`
+	}{}
+	if err := json.Unmarshal(raw, &head); err != nil {
+		return nil, err
+	}
+
+	out := NewFeatureCollection()
+
+	switch head.Type {
+	case "FeatureCollection":
+		return out, json.Unmarshal(raw, out)
+
+	case "Feature":
+		f := &Feature{}
+		if err := json.Unmarshal(raw, f); err != nil {
+			return nil, err
+		}
+		out.Features = append(out.Features, f)
+
+	case "":
+		return nil, errors.New("Document has no GeoJSON type")
+
+	default:
+		g := &Geometry{}
+		if err := json.Unmarshal(raw, g); err != nil {
+			return nil, err
+		}
+		out.Features = append(out.Features, NewFeature(g, nil))
+	}
+
+	return out, nil
+}
+
+// WriteGeoJSONData writes the feature collection as indented JSON
+func WriteGeoJSONData(out io.Writer, fc *FeatureCollection) error {
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(fc)
+}
+
+// ToWaypoints converts all Point and MultiPoint features into GPX
+// waypoints. Properties matching GPX waypoint fields (name, cmt, desc,
+// sym, type, time, ele, hdop) are mapped to those fields, all other
+// properties are passed as tags.
+func ToWaypoints(fc *FeatureCollection) (*gpx.GPX, error) {
+	out := &gpx.GPX{}
+
+	for i, f := range fc.Features {
+		if f.Geometry == nil {
+			continue
+		}
+
+		coords := [][]float64{}
+		switch f.Geometry.Type {
+		case "Point":
+			lon, lat, err := f.Geometry.Point()
+			if err != nil {
+				return nil, fmt.Errorf("Feature %d: %s", i, err)
+			}
+			coords = append(coords, []float64{lon, lat})
+
+		case "MultiPoint":
+			c, err := f.Geometry.MultiPoint()
+			if err != nil {
+				return nil, fmt.Errorf("Feature %d: %s", i, err)
+			}
+			coords = c
+
+		default:
+			continue
+		}
+
+		wp, err := propertiesToWaypoint(f.Properties)
+		if err != nil {
+			return nil, fmt.Errorf("Feature %d: %s", i, err)
+		}
+
+		for _, c := range coords {
+			if len(c) < 2 {
+				return nil, fmt.Errorf("Feature %d: Point needs at least two coordinates", i)
+			}
+
+			p := wp
+			p.Longitude, p.Latitude = c[0], c[1]
+			if len(c) > 2 {
+				p.Elevation = c[2]
+			}
+			out.Waypoints = append(out.Waypoints, p)
+		}
+	}
+
+	return out, nil
+}
+
+func propertiesToWaypoint(props map[string]interface{}) (gpx.Waypoint, error) {
+	wp := gpx.Waypoint{Tags: map[string]string{}}
+
+	for k, v := range props {
+		if v == nil {
+			continue
+		}
+
+		s := PropertyString(v)
+
+		switch k {
+		case "name":
+			wp.Name = s
+		case "cmt", "comment":
+			wp.Comment = s
+		case "desc", "description":
+			wp.Description = s
+		case "sym":
+			wp.Symbol = s
+		case "type":
+			wp.Type = s
+		case "time":
+			t, err := time.Parse(time.RFC3339, s)
+			if err != nil {
+				return wp, err
+			}
+			wp.Time = t
+		case "ele":
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return wp, err
+			}
+			wp.Elevation = f
+		case "hdop":
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return wp, err
+			}
+			wp.HDOP = f
+		default:
+			wp.Tags[k] = s
+		}
+	}
+
+	return wp, nil
+}
+
+// PropertyString converts a property value into its string representation
+func PropertyString(v interface{}) string {
+	switch t := v.(type) {
+	case string:
+		return t
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(t)
+	default:
+		b, _ := json.Marshal(t)
+		return string(b)
+	}
+}
@@ -23,6 +23,7 @@ type GPX struct {
 		} `xml:"bounds"`
 	} `xml:"metadata"`
 	Waypoints []Waypoint `xml:"wpt"`
+	Tracks    []Track    `xml:"trk"`
 }
 
 // Waypoint represents a single waypoint inside a GPX file
@@ -37,6 +38,31 @@ type Waypoint struct {
 	Description string    `xml:"desc"`
 	Symbol      string    `xml:"sym"`
 	Type        string    `xml:"type"`
+	Satellites  int64     `xml:"sat"`
+	HDOP        float64   `xml:"hdop"`
+
+	// Tags contains explicit OSM tags for the waypoint. They are not part
+	// of the GPX format and are only filled by other input formats
+	Tags map[string]string `xml:"-"`
+}
+
+// Track represents a recorded track consisting of one or more segments
+type Track struct {
+	Name     string         `xml:"name"`
+	Segments []TrackSegment `xml:"trkseg"`
+}
+
+// TrackSegment is a continuous part of a track
+type TrackSegment struct {
+	Points []TrackPoint `xml:"trkpt"`
+}
+
+// TrackPoint represents a single recorded position of a track
+type TrackPoint struct {
+	Latitude  float64   `xml:"lat,attr"`
+	Longitude float64   `xml:"lon,attr"`
+	Elevation float64   `xml:"ele"`
+	Time      time.Time `xml:"time"`
 }
 
 // ParseGPXData reads the contents of the GPX file and returns a parsed version
@@ -44,3 +70,111 @@ func ParseGPXData(in io.Reader) (*GPX, error) {
 	out := &GPX{}
 	return out, xml.NewDecoder(in).Decode(out)
 }
+
+type xmlGPX struct {
+	XMLName   xml.Name      `xml:"http://www.topografix.com/GPX/1/1 gpx"`
+	Version   string        `xml:"version,attr"`
+	Creator   string        `xml:"creator,attr"`
+	Metadata  *xmlMetadata  `xml:"metadata,omitempty"`
+	Waypoints []xmlWaypoint `xml:"wpt"`
+	Tracks    []xmlTrack    `xml:"trk"`
+}
+
+type xmlMetadata struct {
+	Link *xmlLink `xml:"link,omitempty"`
+	Time string   `xml:"time,omitempty"`
+}
+
+type xmlLink struct {
+	Href string `xml:"href,attr"`
+	Text string `xml:"text,omitempty"`
+}
+
+type xmlTrack struct {
+	Name     string            `xml:"name,omitempty"`
+	Segments []xmlTrackSegment `xml:"trkseg"`
+}
+
+type xmlTrackSegment struct {
+	Points []xmlTrackPoint `xml:"trkpt"`
+}
+
+type xmlTrackPoint struct {
+	Latitude  float64 `xml:"lat,attr"`
+	Longitude float64 `xml:"lon,attr"`
+	Elevation float64 `xml:"ele,omitempty"`
+	Time      string  `xml:"time,omitempty"`
+}
+
+type xmlWaypoint struct {
+	Latitude    float64 `xml:"lat,attr"`
+	Longitude   float64 `xml:"lon,attr"`
+	Elevation   float64 `xml:"ele"`
+	Time        string  `xml:"time,omitempty"`
+	Name        string  `xml:"name,omitempty"`
+	Comment     string  `xml:"cmt,omitempty"`
+	Description string  `xml:"desc,omitempty"`
+	Symbol      string  `xml:"sym,omitempty"`
+	Type        string  `xml:"type,omitempty"`
+	Satellites  int64   `xml:"sat,omitempty"`
+	HDOP        float64 `xml:"hdop,omitempty"`
+}
+
+// WriteGPXData writes the waypoints and tracks in the GPX as a GPX 1.1
+// document
+func WriteGPXData(out io.Writer, in *GPX, creator string) error {
+	doc := xmlGPX{Version: "1.1", Creator: creator}
+
+	if in.Metadata.Link.Href != "" || !in.Metadata.Time.IsZero() {
+		doc.Metadata = &xmlMetadata{}
+		if in.Metadata.Link.Href != "" {
+			doc.Metadata.Link = &xmlLink{Href: in.Metadata.Link.Href, Text: in.Metadata.Link.Text}
+		}
+		if !in.Metadata.Time.IsZero() {
+			doc.Metadata.Time = in.Metadata.Time.UTC().Format(time.RFC3339)
+		}
+	}
+
+	for _, wp := range in.Waypoints {
+		xwp := xmlWaypoint{
+			Latitude:    wp.Latitude,
+			Longitude:   wp.Longitude,
+			Elevation:   wp.Elevation,
+			Name:        wp.Name,
+			Comment:     wp.Comment,
+			Description: wp.Description,
+			Symbol:      wp.Symbol,
+			Type:        wp.Type,
+			Satellites:  wp.Satellites,
+			HDOP:        wp.HDOP,
+		}
+		if !wp.Time.IsZero() {
+			xwp.Time = wp.Time.UTC().Format(time.RFC3339)
+		}
+		doc.Waypoints = append(doc.Waypoints, xwp)
+	}
+
+	for _, t := range in.Tracks {
+		xt := xmlTrack{Name: t.Name}
+		for _, seg := range t.Segments {
+			xs := xmlTrackSegment{}
+			for _, p := range seg.Points {
+				xp := xmlTrackPoint{Latitude: p.Latitude, Longitude: p.Longitude, Elevation: p.Elevation}
+				if !p.Time.IsZero() {
+					xp.Time = p.Time.UTC().Format(time.RFC3339)
+				}
+				xs.Points = append(xs.Points, xp)
+			}
+			xt.Segments = append(xt.Segments, xs)
+		}
+		doc.Tracks = append(doc.Tracks, xt)
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", "  ")
+	return enc.Encode(doc)
+}
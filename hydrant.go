@@ -3,7 +3,10 @@ package main
 import (
 	"fmt"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/Luzifer/gpxhydrant/gpx"
 	"github.com/Luzifer/gpxhydrant/osm"
@@ -22,6 +25,18 @@ var (
 		"W": "wall",
 		"P": "pond",
 	}
+
+	hydrantCodeRegex        = regexp.MustCompile(`([SPLG])([UOWP])(\?|[0-9]{2,3})`)
+	hydrantRemovedRegex     = regexp.MustCompile(`^\s*X\s*$`)
+	hydrantLenientCodeRegex = regexp.MustCompile(`(?:^|[^A-Z0-9])([SPLG])[\s_./-]*([UOWP])?[\s_./-]*(\?|[0-9]{2,3})(?:[^0-9]|$)`)
+
+	// symbolTypes maps Garmin symbol names to hydrant types, filled
+	// from the symbol-types parameter
+	symbolTypes = map[string]string{}
+
+	// explicitTagKeys limits the tags taken over from input formats
+	// supporting explicit tags (KML extended data, GeoJSON properties)
+	explicitTagKeys = regexp.MustCompile(`^(emergency|operator|ref|note|colour|couplings|water_source|check_date|source)$|^(fire_hydrant|couplings|survey|operator|ref|note):`)
 )
 
 type hydrant struct {
@@ -44,64 +59,224 @@ type hydrant struct {
 	Pressure  int64
 	Type      string
 	Version   int64
+
+	HDOP       float64
+	Sources    []gpx.Waypoint
+	CodeSource string
+	SurveyDate time.Time
+
+	// Removed marks a surveyed position where the hydrant was removed
+	Removed bool
+
+	// ZoneDefaults contains the keys set from the zone defaults. They
+	// were not surveyed and only fill keys missing in OSM.
+	ZoneDefaults map[string]bool
+
+	// Information about the last edit of hydrants read from OSM
+	LastEdit     time.Time
+	LastEditUID  int64
+	LastEditUser string
+
+	// Tags contains all tags not represented by the fields above
+	Tags map[string]string
 }
 
 func parseWaypoint(in gpx.Waypoint) (*hydrant, error) {
-	infoRegex := regexp.MustCompile(`([SPLG])([UOWP])(\?|[0-9]{2,3})`)
-	if !infoRegex.MatchString(in.Comment) {
+	matches, codeSource := findHydrantCode(in)
+
+	// A hydrant code has precedence to not take an X in a note next to
+	// the code as removal marker
+	if field := findRemovedCode(in); matches == nil && field != "" {
+		return &hydrant{
+			Name:      in.Name,
+			Latitude:  roundPrec(in.Latitude, 7),
+			Longitude: roundPrec(in.Longitude, 7),
+
+			HDOP:       in.HDOP,
+			Sources:    []gpx.Waypoint{in},
+			CodeSource: field,
+			SurveyDate: in.Time,
+			Removed:    true,
+		}, nil
+	}
+
+	if matches == nil && !hasHydrantTags(in.Tags) {
 		return nil, errWrongGPXComment
 	}
 
-	matches := infoRegex.FindStringSubmatch(in.Comment)
 	out := &hydrant{
 		Name:      in.Name,
 		Latitude:  roundPrec(in.Latitude, 7),
 		Longitude: roundPrec(in.Longitude, 7),
 		Pressure:  cfg.Pressure,
+
+		HDOP:       in.HDOP,
+		Sources:    []gpx.Waypoint{in},
+		CodeSource: codeSource,
+		SurveyDate: in.Time,
 	}
 
-	out.Position = hydrantPositions[matches[1]]
-	out.Type = hydrantTypes[matches[2]]
+	if matches != nil {
+		out.Position = hydrantPositions[matches[1]]
+		out.Type = hydrantTypes[matches[2]]
+		if out.Type == "" {
+			out.Type = symbolTypes[in.Symbol]
+		}
+
+		if matches[3] != "?" {
+			diameter, err := strconv.ParseInt(matches[3], 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			out.Diameter = diameter
+		}
+	}
+
+	if err := applyZoneDefaults(out); err != nil {
+		return nil, err
+	}
 
-	if matches[3] != "?" {
-		diameter, err := strconv.ParseInt(matches[3], 10, 64)
-		if err != nil {
+	// Explicit tags have precedence over the information in the comment
+	// and the zone defaults
+	if matches == nil {
+		out.CodeSource = "tags"
+	}
+	for _, k := range sortedKeys(in.Tags) {
+		if !explicitTagKeys.MatchString(k) || in.Tags[k] == "" {
+			continue
+		}
+		if err := out.setTag(k, in.Tags[k]); err != nil {
 			return nil, err
 		}
-		out.Diameter = diameter
+		delete(out.ZoneDefaults, k)
 	}
 
+	applyZoneRefPrefix(out)
+
 	return out, nil
 }
 
-func fromNode(in *osm.Node) (*hydrant, error) {
-	var e error
+// findHydrantCode searches the waypoint fields configured in code-fields
+// for a hydrant code and returns the submatches of the code (position,
+// type, diameter) and the field the code was found in. In lenient mode
+// the code may be lower case, contain separators and omit the type if
+// the symbol of the waypoint is mapped to a type.
+func findHydrantCode(in gpx.Waypoint) ([]string, string) {
+	for _, field := range cfg.CodeFields {
+		value := waypointField(in, field)
+
+		if !cfg.Lenient {
+			if m := hydrantCodeRegex.FindStringSubmatch(value); m != nil {
+				return m, field
+			}
+			continue
+		}
+
+		for _, m := range hydrantLenientCodeRegex.FindAllStringSubmatch(strings.ToUpper(value), -1) {
+			if m[2] == "" && symbolTypes[in.Symbol] == "" {
+				// Type is missing and cannot be derived from the symbol
+				continue
+			}
+			return m, field
+		}
+	}
+
+	return nil, ""
+}
+
+// findRemovedCode checks the code fields for a field containing only the
+// code marking a removed hydrant and returns the field it was found in
+func findRemovedCode(in gpx.Waypoint) string {
+	for _, field := range cfg.CodeFields {
+		value := waypointField(in, field)
+		if cfg.Lenient {
+			value = strings.ToUpper(value)
+		}
+
+		if hydrantRemovedRegex.MatchString(value) {
+			return field
+		}
+	}
 
+	return ""
+}
+
+func waypointField(in gpx.Waypoint, field string) string {
+	switch field {
+	case "cmt":
+		return in.Comment
+	case "name":
+		return in.Name
+	case "desc":
+		return in.Description
+	case "sym":
+		return in.Symbol
+	case "type":
+		return in.Type
+	default:
+		return ""
+	}
+}
+
+// parseSymbolTypes reads the symbol-types parameter in the format
+// "Flag, Blue=U;Flag, Red=pillar" into a map of symbol to hydrant type
+func parseSymbolTypes(in string) (map[string]string, error) {
+	out := map[string]string{}
+
+	for _, entry := range strings.Split(in, ";") {
+		if strings.TrimSpace(entry) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "=", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Invalid symbol mapping %q", entry)
+		}
+
+		symbol, hType := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if t, ok := hydrantTypes[strings.ToUpper(hType)]; ok {
+			hType = t
+		}
+
+		out[symbol] = hType
+	}
+
+	return out, nil
+}
+
+func hasHydrantTags(tags map[string]string) bool {
+	for k, v := range tags {
+		if (k == "emergency" && v == "fire_hydrant") || strings.HasPrefix(k, "fire_hydrant:") {
+			return true
+		}
+	}
+	return false
+}
+
+func fromNode(in *osm.Node) (*hydrant, error) {
 	out := &hydrant{
 		ID:        in.ID,
 		Version:   in.Version,
 		Latitude:  in.Latitude,
 		Longitude: in.Longitude,
+
+		LastEditUID:  in.UID,
+		LastEditUser: in.User,
+	}
+
+	if in.Timestamp != nil {
+		out.LastEdit = *in.Timestamp
 	}
 
 	validFireHydrant := false
 
 	for _, t := range in.Tags {
-		switch t.Key {
-		case "emergency":
+		if t.Key == "emergency" {
 			validFireHydrant = t.Value == "fire_hydrant"
-		case "fire_hydrant:diameter":
-			if out.Diameter, e = strconv.ParseInt(t.Value, 10, 64); e != nil {
-				return nil, e
-			}
-		case "fire_hydrant:position":
-			out.Position = t.Value
-		case "fire_hydrant:pressure":
-			if out.Pressure, e = strconv.ParseInt(t.Value, 10, 64); e != nil {
-				return nil, e
-			}
-		case "fire_hydrant:type":
-			out.Type = t.Value
+		}
+
+		if err := out.setTag(t.Key, t.Value); err != nil {
+			return nil, err
 		}
 	}
 
@@ -112,6 +287,64 @@ func fromNode(in *osm.Node) (*hydrant, error) {
 	return out, nil
 }
 
+// setTag stores the value of an OSM tag into the matching field of the
+// hydrant or into the Tags map if there is no field for it
+func (h *hydrant) setTag(key, value string) error {
+	var e error
+
+	switch key {
+	case "emergency":
+		// Always set to fire_hydrant by ToNode
+	case "fire_hydrant:diameter":
+		if h.Diameter, e = strconv.ParseInt(value, 10, 64); e != nil {
+			return e
+		}
+	case "fire_hydrant:position":
+		h.Position = value
+	case "fire_hydrant:pressure":
+		if h.Pressure, e = strconv.ParseInt(value, 10, 64); e != nil {
+			return e
+		}
+	case "fire_hydrant:type":
+		h.Type = value
+	default:
+		if h.Tags == nil {
+			h.Tags = map[string]string{}
+		}
+		h.Tags[key] = value
+	}
+
+	return nil
+}
+
+// withTags returns a copy of the hydrant having exactly the given tags
+func (h hydrant) withTags(tags map[string]string) (*hydrant, error) {
+	out := h
+	out.Diameter, out.Position, out.Pressure, out.Type, out.Tags = 0, "", 0, "", nil
+
+	for _, k := range sortedKeys(tags) {
+		if err := out.setTag(k, tags[k]); err != nil {
+			return nil, fmt.Errorf("Invalid value for %s: %s", k, err)
+		}
+	}
+
+	return &out, nil
+}
+
+// MergeTags takes over all tags from the passed hydrant not set in
+// this hydrant to keep them when updating an existing node
+func (h *hydrant) MergeTags(in *hydrant) {
+	for k, v := range in.Tags {
+		if _, ok := h.Tags[k]; ok {
+			continue
+		}
+		if h.Tags == nil {
+			h.Tags = map[string]string{}
+		}
+		h.Tags[k] = v
+	}
+}
+
 func (h hydrant) ToNode() *osm.Node {
 	out := &osm.Node{
 		ID:        h.ID,
@@ -124,13 +357,48 @@ func (h hydrant) ToNode() *osm.Node {
 	if h.Diameter > 0 {
 		out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:diameter", Value: strconv.FormatInt(h.Diameter, 10)})
 	}
-	out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:position", Value: h.Position})
-	out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:pressure", Value: strconv.FormatInt(h.Pressure, 10)})
-	out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:type", Value: h.Type})
+	if h.Position != "" {
+		out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:position", Value: h.Position})
+	}
+	if h.Pressure > 0 {
+		out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:pressure", Value: strconv.FormatInt(h.Pressure, 10)})
+	}
+	if h.Type != "" {
+		out.Tags = append(out.Tags, osm.Tag{Key: "fire_hydrant:type", Value: h.Type})
+	}
+
+	for _, k := range sortedKeys(h.Tags) {
+		out.Tags = append(out.Tags, osm.Tag{Key: k, Value: h.Tags[k]})
+	}
 
 	return out
 }
 
 func (h hydrant) NeedsUpdate(in *hydrant) bool {
+	for k, v := range in.Tags {
+		if h.Tags[k] != v {
+			return true
+		}
+	}
+
 	return h.Diameter != in.Diameter || h.Position != in.Position || h.Pressure != in.Pressure || h.Type != in.Type
 }
+
+// CompatibleWith checks whether both hydrants might describe the same
+// physical hydrant: Position and type need to match, the diameter needs
+// to match if known in both.
+func (h hydrant) CompatibleWith(in *hydrant) bool {
+	if h.Diameter > 0 && in.Diameter > 0 && h.Diameter != in.Diameter {
+		return false
+	}
+	return h.Removed == in.Removed && h.Position == in.Position && h.Type == in.Type
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := []string{}
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
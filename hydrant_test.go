@@ -0,0 +1,110 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+)
+
+func TestParseWaypointCodes(t *testing.T) {
+	defer func(lenient bool, fields []string, symbols map[string]string) {
+		cfg.Lenient, cfg.CodeFields, symbolTypes = lenient, fields, symbols
+	}(cfg.Lenient, cfg.CodeFields, symbolTypes)
+
+	symbolTypes = map[string]string{"Flag, Red": "pillar"}
+
+	type expect struct {
+		Position, Type string
+		Diameter       int64
+		Removed        bool
+		CodeSource     string
+	}
+
+	for _, c := range []struct {
+		Name     string
+		Lenient  bool
+		Fields   []string
+		Waypoint gpx.Waypoint
+		Expected *expect
+	}{
+		// Strict codes
+		{"strict code", false, []string{"cmt"}, gpx.Waypoint{Comment: "SU100"}, &expect{"sidewalk", "underground", 100, false, "cmt"}},
+		{"strict code in text", false, []string{"cmt"}, gpx.Waypoint{Comment: "Hydrant LO80 at the corner"}, &expect{"lane", "pillar", 80, false, "cmt"}},
+		{"strict unknown diameter", false, []string{"cmt"}, gpx.Waypoint{Comment: "GW?"}, &expect{"green", "wall", 0, false, "cmt"}},
+		{"strict lower case", false, []string{"cmt"}, gpx.Waypoint{Comment: "su100"}, nil},
+		{"strict separators", false, []string{"cmt"}, gpx.Waypoint{Comment: "S-U 100"}, nil},
+
+		// Lenient separators and case
+		{"lenient lower case", true, []string{"cmt"}, gpx.Waypoint{Comment: "su100"}, &expect{"sidewalk", "underground", 100, false, "cmt"}},
+		{"lenient dash and space", true, []string{"cmt"}, gpx.Waypoint{Comment: "S-U 100"}, &expect{"sidewalk", "underground", 100, false, "cmt"}},
+		{"lenient slash and dot", true, []string{"cmt"}, gpx.Waypoint{Comment: "p/o.80"}, &expect{"parking_lot", "pillar", 80, false, "cmt"}},
+		{"lenient underscores", true, []string{"cmt"}, gpx.Waypoint{Comment: "g_p_150"}, &expect{"green", "pond", 150, false, "cmt"}},
+		{"lenient code in text", true, []string{"cmt"}, gpx.Waypoint{Comment: "near lu 125, broken"}, &expect{"lane", "underground", 125, false, "cmt"}},
+
+		// Type letter filled from the symbol
+		{"type from symbol", true, []string{"cmt"}, gpx.Waypoint{Comment: "S100", Symbol: "Flag, Red"}, &expect{"sidewalk", "pillar", 100, false, "cmt"}},
+		{"type letter wins over symbol", true, []string{"cmt"}, gpx.Waypoint{Comment: "SU100", Symbol: "Flag, Red"}, &expect{"sidewalk", "underground", 100, false, "cmt"}},
+		{"type missing without symbol", true, []string{"cmt"}, gpx.Waypoint{Comment: "S100"}, nil},
+		{"type missing with unmapped symbol", true, []string{"cmt"}, gpx.Waypoint{Comment: "S100", Symbol: "Flag, Blue"}, nil},
+
+		// Code fields
+		{"code in name", true, []string{"cmt", "name", "desc"}, gpx.Waypoint{Name: "SU100"}, &expect{"sidewalk", "underground", 100, false, "name"}},
+		{"code in desc", true, []string{"cmt", "name", "desc"}, gpx.Waypoint{Name: "001", Description: "lw 65"}, &expect{"lane", "wall", 65, false, "desc"}},
+		{"code in sym", true, []string{"sym"}, gpx.Waypoint{Symbol: "SU100"}, &expect{"sidewalk", "underground", 100, false, "sym"}},
+		{"code in type", true, []string{"type"}, gpx.Waypoint{Type: "po 80"}, &expect{"parking_lot", "pillar", 80, false, "type"}},
+		{"first field wins", true, []string{"name", "cmt"}, gpx.Waypoint{Name: "SU100", Comment: "PO80"}, &expect{"sidewalk", "underground", 100, false, "name"}},
+		{"field not configured", true, []string{"cmt"}, gpx.Waypoint{Name: "SU100"}, nil},
+
+		// Removal marker
+		{"removed", false, []string{"cmt"}, gpx.Waypoint{Comment: " X "}, &expect{"", "", 0, true, "cmt"}},
+		{"lenient removed", true, []string{"cmt"}, gpx.Waypoint{Comment: "x"}, &expect{"", "", 0, true, "cmt"}},
+		{"code next to X", true, []string{"cmt", "desc"}, gpx.Waypoint{Comment: "SU100 X", Description: "X"}, &expect{"sidewalk", "underground", 100, false, "cmt"}},
+		{"X in a note", true, []string{"cmt"}, gpx.Waypoint{Comment: "X marks the spot"}, nil},
+
+		// Non-matches
+		{"empty", true, []string{"cmt"}, gpx.Waypoint{}, nil},
+		{"plain text", true, []string{"cmt"}, gpx.Waypoint{Comment: "GPS 100m north"}, nil},
+		{"diameter too short", true, []string{"cmt"}, gpx.Waypoint{Comment: "SU1"}, nil},
+		{"diameter too long", true, []string{"cmt"}, gpx.Waypoint{Comment: "SU1000"}, nil},
+		{"inside a word", true, []string{"cmt"}, gpx.Waypoint{Comment: "ASU100"}, nil},
+		{"unknown position", true, []string{"cmt"}, gpx.Waypoint{Comment: "XU100"}, nil},
+		{"unknown type", true, []string{"cmt"}, gpx.Waypoint{Comment: "SX100"}, nil},
+	} {
+		cfg.Lenient, cfg.CodeFields = c.Lenient, c.Fields
+
+		h, err := parseWaypoint(c.Waypoint)
+		if c.Expected == nil {
+			if err != errWrongGPXComment {
+				t.Errorf("%s: expected no code to be found, got %#v (%v)", c.Name, h, err)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.Name, err)
+			continue
+		}
+
+		got := expect{h.Position, h.Type, h.Diameter, h.Removed, h.CodeSource}
+		if got != *c.Expected {
+			t.Errorf("%s: expected %#v, got %#v", c.Name, *c.Expected, got)
+		}
+	}
+}
+
+func TestParseSymbolTypes(t *testing.T) {
+	got, err := parseSymbolTypes("Flag, Blue=U; Flag, Red=pillar;;Pin=O")
+	if err != nil {
+		t.Fatalf("Unable to parse symbol types: %s", err)
+	}
+
+	for symbol, hType := range map[string]string{"Flag, Blue": "underground", "Flag, Red": "pillar", "Pin": "pillar"} {
+		if got[symbol] != hType {
+			t.Errorf("Expected %q to be mapped to %q, got %q", symbol, hType, got[symbol])
+		}
+	}
+
+	if _, err := parseSymbolTypes("Flag, Blue"); err == nil {
+		t.Error("Expected error for mapping without type")
+	}
+}
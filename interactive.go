@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// reviewChanges steps through all planned creates, modifications and
+// deletions and asks whether to send them. Changes not accepted are
+// skipped, creates held back for review are only created if accepted.
+func reviewChanges(in io.Reader, out io.Writer, changes []*plannedChange, availableHydrants []*hydrant, policy *updatePolicy, currentUID int64) error {
+	var (
+		scanner = bufio.NewScanner(in)
+		review  = []*plannedChange{}
+	)
+
+	for _, c := range changes {
+		switch c.Action {
+		case actionCreate, actionModify, actionDelete, actionReview:
+			review = append(review, c)
+		}
+	}
+
+	for i, c := range review {
+		if c.Action == actionReview {
+			// Show what would be created when accepting it
+			applySurveyDate(c.Hydrant, nil)
+			c.Target, c.Node = c.Hydrant, c.Hydrant.ToNode()
+		}
+
+		accepted, err := reviewChange(scanner, out, fmt.Sprintf("%d/%d", i+1, len(review)), c, availableHydrants, policy, currentUID)
+		if err != nil {
+			return err
+		}
+
+		if accepted == nil {
+			// Quit: skip all remaining changes
+			for _, r := range review[i:] {
+				skipReviewed(r)
+			}
+			return nil
+		}
+
+		if !*accepted {
+			skipReviewed(c)
+		}
+	}
+
+	return nil
+}
+
+// reviewChange asks for a decision about a single change until it is
+// accepted or skipped. nil is returned if the user quits the review.
+func reviewChange(scanner *bufio.Scanner, out io.Writer, progress string, c *plannedChange, availableHydrants []*hydrant, policy *updatePolicy, currentUID int64) (*bool, error) {
+	accept, skip := true, false
+
+	for {
+		printChange(out, progress, c)
+
+		if c.Action == actionSkip || c.Action == actionNone {
+			// Picked candidate leaves nothing to send
+			fmt.Fprint(out, "[s]kip, [c]hoose candidate, [q]uit: ")
+		} else {
+			fmt.Fprint(out, "[a]ccept, [s]kip, [e]dit tags, [c]hoose candidate, [q]uit: ")
+		}
+
+		if !scanner.Scan() {
+			if err := scanner.Err(); err != nil {
+				return nil, err
+			}
+			return nil, nil
+		}
+
+		switch strings.TrimSpace(scanner.Text()) {
+		case "a":
+			if c.Action == actionSkip || c.Action == actionNone {
+				fmt.Fprintln(out, "Nothing to send for this hydrant")
+				continue
+			}
+			if c.Action == actionReview {
+				c.Action, c.Found, c.Distance, c.Reason = actionCreate, nil, 0, ""
+			}
+			return &accept, nil
+
+		case "s":
+			return &skip, nil
+
+		case "e":
+			if err := editTags(scanner, out, c); err != nil {
+				fmt.Fprintf(out, "Unable to edit tags: %s\n", err)
+			}
+
+		case "c":
+			if err := chooseCandidate(scanner, out, c, availableHydrants, policy, currentUID); err != nil {
+				fmt.Fprintf(out, "Unable to choose candidate: %s\n", err)
+			}
+
+		case "q":
+			return nil, nil
+
+		default:
+			fmt.Fprintln(out, "Unknown answer")
+		}
+	}
+}
+
+func skipReviewed(c *plannedChange) {
+	switch c.Action {
+	case actionCreate, actionModify, actionDelete:
+		c.Action, c.Reason = actionSkip, "skipped in interactive review"
+	}
+}
+
+func printChange(out io.Writer, progress string, c *plannedChange) {
+	h := c.Hydrant
+	fmt.Fprintf(out, "\n[%s] Waypoint %s at %.7f,%.7f\n", progress, h.Name, h.Latitude, h.Longitude)
+
+	switch c.Action {
+	case actionCreate:
+		fmt.Fprintln(out, "Create new hydrant")
+	case actionReview:
+		fmt.Fprintf(out, "Create new hydrant, held back: %s\n", c.Reason)
+	case actionModify:
+		fmt.Fprintf(out, "Modify node %d (%.1fm away)\n", c.Found.ID, c.Distance)
+	case actionDelete:
+		fmt.Fprintf(out, "DELETE node %d (%.1fm away)\n", c.Found.ID, c.Distance)
+	case actionNone:
+		fmt.Fprintf(out, "Node %d (%.1fm away) needs no update\n", c.Found.ID, c.Distance)
+	case actionSkip:
+		fmt.Fprintf(out, "Skip: %s\n", c.Reason)
+	}
+
+	if c.Node == nil {
+		return
+	}
+
+	var before map[string]string
+	if c.Found != nil && c.Action != actionReview {
+		before = nodeTagMap(c.Found.ToNode())
+	}
+
+	for _, line := range tagDiff(before, nodeTagMap(c.Node), c.Action == actionDelete) {
+		fmt.Fprintf(out, "  %s\n", line)
+	}
+}
+
+// tagDiff lists all tags prefixed with "+" (added), "-" (removed), "~"
+// (changed) or " " (unchanged)
+func tagDiff(before, after map[string]string, deleted bool) []string {
+	keys := map[string]string{}
+	for k := range before {
+		keys[k] = ""
+	}
+	for k := range after {
+		keys[k] = ""
+	}
+
+	out := []string{}
+	for _, k := range sortedKeys(keys) {
+		oldValue, inBefore := before[k]
+		newValue, inAfter := after[k]
+
+		switch {
+		case deleted:
+			out = append(out, fmt.Sprintf("- %s=%s", k, newValue))
+		case !inBefore:
+			out = append(out, fmt.Sprintf("+ %s=%s", k, newValue))
+		case !inAfter:
+			out = append(out, fmt.Sprintf("- %s=%s", k, oldValue))
+		case oldValue != newValue:
+			out = append(out, fmt.Sprintf("~ %s=%s (was %s)", k, newValue, oldValue))
+		default:
+			out = append(out, fmt.Sprintf("  %s=%s", k, newValue))
+		}
+	}
+
+	return out
+}
+
+func formatTags(tags map[string]string) string {
+	out := []string{}
+	for _, k := range sortedKeys(tags) {
+		out = append(out, k+"="+tags[k])
+	}
+	return strings.Join(out, ", ")
+}
+
+// editTags reads "key=value" lines changing the tags of the target until
+// an empty line is entered, "key=" removes the tag
+func editTags(scanner *bufio.Scanner, out io.Writer, c *plannedChange) error {
+	if c.Target == nil || c.Hydrant.Removed {
+		return fmt.Errorf("tags of removed hydrants can not be edited")
+	}
+
+	tags := tagMap(c.Target)
+
+	for {
+		fmt.Fprint(out, "Tag (key=value, key= to remove, empty line to finish): ")
+		if !scanner.Scan() {
+			break
+		}
+
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			break
+		}
+
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			fmt.Fprintf(out, "Invalid tag %q\n", line)
+			continue
+		}
+
+		key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+		if value == "" {
+			delete(tags, key)
+			continue
+		}
+		tags[key] = value
+	}
+
+	target, err := c.Target.withTags(tags)
+	if err != nil {
+		return err
+	}
+
+	c.Target, c.Node = target, target.ToNode()
+	if c.Action == actionNone {
+		c.Action = actionModify
+	}
+
+	return nil
+}
+
+// chooseCandidate lists the hydrants near the surveyed hydrant and plans
+// the change against the chosen one or a new hydrant
+func chooseCandidate(scanner *bufio.Scanner, out io.Writer, c *plannedChange, availableHydrants []*hydrant, policy *updatePolicy, currentUID int64) error {
+	candidates := nearbyHydrants(c.Hydrant, availableHydrants, math.Max(float64(cfg.MachRange), float64(cfg.CautionRange)))
+
+	fmt.Fprintln(out, "  0) create a new hydrant")
+	for i, cand := range candidates {
+		fmt.Fprintf(out, "  %d) node %d (%.1fm away): %s\n", i+1, cand.ID, hydrantDistance(c.Hydrant, cand),
+			formatTags(tagMap(cand)))
+	}
+
+	fmt.Fprint(out, "Candidate: ")
+	if !scanner.Scan() {
+		return scanner.Err()
+	}
+
+	idx, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || idx < 0 || idx > len(candidates) {
+		return fmt.Errorf("invalid candidate %q", scanner.Text())
+	}
+
+	if idx == 0 {
+		if c.Hydrant.Removed {
+			return fmt.Errorf("can not create a removed hydrant")
+		}
+		planCreate(c)
+		return nil
+	}
+
+	c.Found = candidates[idx-1]
+	c.Distance = hydrantDistance(c.Hydrant, c.Found)
+	if err := planMatch(c, policy, currentUID); err != nil {
+		return err
+	}
+	logPolicyDecisions(c.Decisions)
+
+	return nil
+}
+
+// nearbyHydrants returns all available hydrants within the given range in
+// meters, the nearest first
+func nearbyHydrants(h *hydrant, availableHydrants []*hydrant, maxRange float64) []*hydrant {
+	out := []*hydrant{}
+	for _, a := range availableHydrants {
+		if hydrantDistance(h, a) <= maxRange {
+			out = append(out, a)
+		}
+	}
+
+	sort.Slice(out, func(i, j int) bool { return hydrantDistance(h, out[i]) < hydrantDistance(h, out[j]) })
+
+	return out
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func testReviewChanges() []*plannedChange {
+	newHydrant := func(id int64, name string) *hydrant {
+		return &hydrant{ID: id, Name: name, Latitude: 53.6, Longitude: 9.7, Type: "underground"}
+	}
+
+	create := &plannedChange{Action: actionCreate, Hydrant: newHydrant(0, "001")}
+	create.Target, create.Node = create.Hydrant, create.Hydrant.ToNode()
+
+	modify := &plannedChange{Action: actionModify, Hydrant: newHydrant(0, "002"), Found: newHydrant(5, "")}
+	modify.Target, modify.Node = newHydrant(5, "002"), newHydrant(5, "002").ToNode()
+
+	review := &plannedChange{Action: actionReview, Hydrant: newHydrant(0, "003"), Found: newHydrant(6, ""), Reason: "in caution range"}
+
+	remove := &plannedChange{Action: actionDelete, Hydrant: newHydrant(0, "004"), Found: newHydrant(7, "")}
+	remove.Node = remove.Found.ToNode()
+
+	none := &plannedChange{Action: actionNone, Hydrant: newHydrant(0, "005"), Found: newHydrant(8, "")}
+
+	return []*plannedChange{create, modify, review, remove, none}
+}
+
+func TestReviewChanges(t *testing.T) {
+	for _, c := range []struct {
+		Name     string
+		Input    string
+		Expected []changeAction
+	}{
+		{
+			Name:     "accept all",
+			Input:    "a\na\na\na\n",
+			Expected: []changeAction{actionCreate, actionModify, actionCreate, actionDelete, actionNone},
+		},
+		{
+			Name:     "skip and unknown answers",
+			Input:    "x\ns\na\ns\na\n",
+			Expected: []changeAction{actionSkip, actionModify, actionReview, actionDelete, actionNone},
+		},
+		{
+			Name:     "quit",
+			Input:    "a\nq\n",
+			Expected: []changeAction{actionCreate, actionSkip, actionReview, actionSkip, actionNone},
+		},
+		{
+			Name:     "end of input",
+			Input:    "a\n",
+			Expected: []changeAction{actionCreate, actionSkip, actionReview, actionSkip, actionNone},
+		},
+	} {
+		changes := testReviewChanges()
+		out := new(bytes.Buffer)
+
+		if err := reviewChanges(strings.NewReader(c.Input), out, changes, nil, nil, 0); err != nil {
+			t.Fatalf("%s: review failed: %s", c.Name, err)
+		}
+
+		for i, ch := range changes {
+			if ch.Action != c.Expected[i] {
+				t.Errorf("%s: expected change %d to be %s, got %s", c.Name, i+1, c.Expected[i], ch.Action)
+			}
+		}
+	}
+}
+
+func TestReviewChangesEditTags(t *testing.T) {
+	changes := testReviewChanges()[:1]
+	out := new(bytes.Buffer)
+
+	input := "e\nfire_hydrant:diameter=100\ncolour=red\ninvalid\nfire_hydrant:type=\n\na\n"
+	if err := reviewChanges(strings.NewReader(input), out, changes, nil, nil, 0); err != nil {
+		t.Fatalf("Review failed: %s", err)
+	}
+
+	c := changes[0]
+	if c.Action != actionCreate {
+		t.Fatalf("Expected change to be accepted, got %s", c.Action)
+	}
+
+	tags := nodeTagMap(c.Node)
+	for k, v := range map[string]string{"fire_hydrant:diameter": "100", "colour": "red", "fire_hydrant:type": ""} {
+		if tags[k] != v {
+			t.Errorf("Expected tag %s to be %q, got %q", k, v, tags[k])
+		}
+	}
+
+	if !strings.Contains(out.String(), `Invalid tag "invalid"`) {
+		t.Errorf("Expected invalid tag to be reported, got output:\n%s", out.String())
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+)
+
+// journalEntry records a successful write for one source waypoint
+type journalEntry struct {
+	Waypoint  string       `json:"waypoint"`
+	Action    changeAction `json:"action"`
+	NodeID    int64        `json:"node_id"`
+	Version   int64        `json:"version"`
+	Changeset int64        `json:"changeset"`
+	Time      time.Time    `json:"time"`
+}
+
+// journal is an append-only file of all successful writes used to skip
+// already uploaded changes when a run is repeated
+type journal struct {
+	filename string
+	entries  map[string]journalEntry
+}
+
+// openJournal reads the journal file, an empty filename disables the
+// journal
+func openJournal(filename string) (*journal, error) {
+	j := &journal{filename: filename, entries: map[string]journalEntry{}}
+	if filename == "" {
+		return j, nil
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return j, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		e := journalEntry{}
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("Invalid entry in line %d: %s", line, err)
+		}
+		j.entries[e.Waypoint] = e
+	}
+
+	return j, scanner.Err()
+}
+
+// journalKey identifies a source waypoint by its name and position
+func journalKey(wp gpx.Waypoint) string {
+	return fmt.Sprintf("%s@%.7f,%.7f", wp.Name, wp.Latitude, wp.Longitude)
+}
+
+// Completed returns the entry of an earlier write if all source
+// waypoints of the hydrant were already written, a nil journal has no
+// entries
+func (j *journal) Completed(h *hydrant) (journalEntry, bool) {
+	var last journalEntry
+
+	if j == nil || len(h.Sources) == 0 {
+		return last, false
+	}
+
+	for _, wp := range h.Sources {
+		e, ok := j.entries[journalKey(wp)]
+		if !ok {
+			return last, false
+		}
+		last = e
+	}
+
+	return last, true
+}
+
+// Deleted checks whether a deletion of the node was recorded
+func (j *journal) Deleted(nodeID int64) bool {
+	if j == nil {
+		return false
+	}
+
+	for _, e := range j.entries {
+		if e.Action == actionDelete && e.NodeID == nodeID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Record appends an entry for every source waypoint of the change and
+// syncs the file to have it stored before continuing
+func (j *journal) Record(c *plannedChange, changesetID int64) error {
+	if j.filename == "" {
+		return nil
+	}
+
+	f, err := os.OpenFile(j.filename, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	for _, wp := range c.Hydrant.Sources {
+		e := journalEntry{
+			Waypoint:  journalKey(wp),
+			Action:    c.Action,
+			NodeID:    c.Node.ID,
+			Version:   c.Node.Version,
+			Changeset: changesetID,
+			Time:      time.Now().UTC(),
+		}
+
+		if err := enc.Encode(e); err != nil {
+			f.Close()
+			return err
+		}
+		j.entries[e.Waypoint] = e
+	}
+
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,263 @@
+package kml
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+)
+
+const kmlNamespace = "http://www.opengis.net/kml/2.2"
+
+// Placemark represents a single point placemark inside a KML document
+type Placemark struct {
+	Name        string
+	Description string
+	Time        time.Time
+	Latitude    float64
+	Longitude   float64
+	Elevation   float64
+
+	// Data holds the contents of the ExtendedData block
+	Data map[string]string
+}
+
+type xmlData struct {
+	Name  string `xml:"name,attr"`
+	Value string `xml:"value"`
+}
+
+type xmlTimeStamp struct {
+	When string `xml:"when"`
+}
+
+type xmlPlacemark struct {
+	Name        string       `xml:"name"`
+	Description string       `xml:"description"`
+	TimeStamp   xmlTimeStamp `xml:"TimeStamp"`
+	Point       *struct {
+		Coordinates string `xml:"coordinates"`
+	} `xml:"Point"`
+	ExtendedData struct {
+		Data       []xmlData `xml:"Data"`
+		SimpleData []struct {
+			Name  string `xml:"name,attr"`
+			Value string `xml:",chardata"`
+		} `xml:"SchemaData>SimpleData"`
+	} `xml:"ExtendedData"`
+}
+
+// ParseKMLData reads all point placemarks from the KML document,
+// placemarks having no point geometry are ignored
+func ParseKMLData(in io.Reader) ([]Placemark, error) {
+	out := []Placemark{}
+	dec := xml.NewDecoder(in)
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			return out, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		se, ok := tok.(xml.StartElement)
+		if !ok || se.Name.Local != "Placemark" {
+			continue
+		}
+
+		p := xmlPlacemark{}
+		if err := dec.DecodeElement(&p, &se); err != nil {
+			return nil, err
+		}
+
+		if p.Point == nil {
+			continue
+		}
+
+		pm, err := p.toPlacemark()
+		if err != nil {
+			return nil, fmt.Errorf("Placemark %q: %s", p.Name, err)
+		}
+		out = append(out, pm)
+	}
+}
+
+// ParseKMZData reads the first KML document inside the KMZ archive
+func ParseKMZData(in io.ReaderAt, size int64) ([]Placemark, error) {
+	zr, err := zip.NewReader(in, size)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if strings.ToLower(path.Ext(f.Name)) != ".kml" {
+			continue
+		}
+
+		r, err := f.Open()
+		if err != nil {
+			return nil, err
+		}
+		defer r.Close()
+
+		return ParseKMLData(r)
+	}
+
+	return nil, errors.New("KMZ archive does not contain a KML document")
+}
+
+func (p xmlPlacemark) toPlacemark() (Placemark, error) {
+	out := Placemark{
+		Name:        strings.TrimSpace(p.Name),
+		Description: strings.TrimSpace(p.Description),
+		Data:        map[string]string{},
+	}
+
+	coords := strings.Split(strings.TrimSpace(p.Point.Coordinates), ",")
+	if len(coords) < 2 {
+		return out, fmt.Errorf("Invalid coordinates %q", p.Point.Coordinates)
+	}
+
+	var err error
+	if out.Longitude, err = strconv.ParseFloat(strings.TrimSpace(coords[0]), 64); err != nil {
+		return out, err
+	}
+	if out.Latitude, err = strconv.ParseFloat(strings.TrimSpace(coords[1]), 64); err != nil {
+		return out, err
+	}
+	if len(coords) > 2 {
+		if out.Elevation, err = strconv.ParseFloat(strings.TrimSpace(coords[2]), 64); err != nil {
+			return out, err
+		}
+	}
+
+	if p.TimeStamp.When != "" {
+		if out.Time, err = time.Parse(time.RFC3339, strings.TrimSpace(p.TimeStamp.When)); err != nil {
+			return out, err
+		}
+	}
+
+	for _, d := range p.ExtendedData.Data {
+		out.Data[d.Name] = strings.TrimSpace(d.Value)
+	}
+	for _, d := range p.ExtendedData.SimpleData {
+		out.Data[d.Name] = strings.TrimSpace(d.Value)
+	}
+
+	return out, nil
+}
+
+// ToWaypoints converts placemarks into GPX waypoints. The description is
+// used as waypoint comment unless a "cmt" entry is present in the
+// extended data, all other extended data entries are passed as tags.
+func ToWaypoints(placemarks []Placemark) *gpx.GPX {
+	out := &gpx.GPX{}
+
+	for _, p := range placemarks {
+		wp := gpx.Waypoint{
+			Latitude:    p.Latitude,
+			Longitude:   p.Longitude,
+			Elevation:   p.Elevation,
+			Time:        p.Time,
+			Name:        p.Name,
+			Comment:     p.Description,
+			Description: p.Description,
+			Tags:        map[string]string{},
+		}
+
+		for k, v := range p.Data {
+			switch k {
+			case "cmt", "comment":
+				wp.Comment = v
+			case "sym":
+				wp.Symbol = v
+			case "type":
+				wp.Type = v
+			default:
+				wp.Tags[k] = v
+			}
+		}
+
+		out.Waypoints = append(out.Waypoints, wp)
+	}
+
+	return out
+}
+
+type xmlDocument struct {
+	XMLName    xml.Name          `xml:"kml"`
+	Namespace  string            `xml:"xmlns,attr"`
+	Name       string            `xml:"Document>name,omitempty"`
+	Placemarks []xmlOutPlacemark `xml:"Document>Placemark"`
+}
+
+type xmlOutPlacemark struct {
+	Name        string        `xml:"name,omitempty"`
+	Description string        `xml:"description,omitempty"`
+	TimeStamp   *xmlTimeStamp `xml:"TimeStamp,omitempty"`
+	Data        []xmlData     `xml:"ExtendedData>Data"`
+	Coordinates string        `xml:"Point>coordinates"`
+}
+
+// WriteKMLData writes the placemarks as a KML document with the given name
+func WriteKMLData(out io.Writer, name string, placemarks []Placemark) error {
+	doc := xmlDocument{Namespace: kmlNamespace, Name: name}
+
+	for _, p := range placemarks {
+		xp := xmlOutPlacemark{
+			Name:        p.Name,
+			Description: p.Description,
+			Coordinates: fmt.Sprintf("%.7f,%.7f,%.1f", p.Longitude, p.Latitude, p.Elevation),
+		}
+
+		if !p.Time.IsZero() {
+			xp.TimeStamp = &xmlTimeStamp{When: p.Time.UTC().Format(time.RFC3339)}
+		}
+
+		keys := []string{}
+		for k := range p.Data {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			xp.Data = append(xp.Data, xmlData{Name: k, Value: p.Data[k]})
+		}
+
+		doc.Placemarks = append(doc.Placemarks, xp)
+	}
+
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", " ")
+	return enc.Encode(doc)
+}
+
+// WriteKMZData writes the placemarks as a KML document packed into a KMZ archive
+func WriteKMZData(out io.Writer, name string, placemarks []Placemark) error {
+	zw := zip.NewWriter(out)
+
+	w, err := zw.Create("doc.kml")
+	if err != nil {
+		return err
+	}
+
+	if err := WriteKMLData(w, name, placemarks); err != nil {
+		return err
+	}
+
+	return zw.Close()
+}
@@ -5,30 +5,78 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 
-	"github.com/Luzifer/go_helpers/position"
-	"github.com/Luzifer/gpxhydrant/gpx"
 	"github.com/Luzifer/gpxhydrant/osm"
 	"github.com/Luzifer/rconfig"
 	log "github.com/Sirupsen/logrus"
 )
 
+// exitCodeWarnings is used when the run completed but waypoints were
+// skipped or need review
+const exitCodeWarnings = 2
+
 var (
 	cfg = struct {
-		Comment   string `flag:"comment,c" default:"Added hydrants from GPX file" description:"Comment for the changeset"`
-		Debug     bool   `flag:"debug,d" default:"false" description:"Enable debug logging (Deprecated: Use --log-level=debug)"`
-		GPXFile   string `flag:"gpx-file,f" description:"File containing GPX waypoints"`
-		LogLevel  string `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error)"`
-		MachRange int64  `flag:"match-range" default:"5" description:"Range of meters to match GPX hydrants to OSM nodes"`
-		NoOp      bool   `flag:"noop,n" default:"false" description:"Fetch data from OSM but do not write"`
-		OSM       struct {
+		AllowDelete     bool     `flag:"allow-delete" default:"false" description:"Allow deleting nodes of removed hydrants (required for removal-mode=delete)"`
+		AllowTypeChange bool     `flag:"allow-type-change" default:"false" description:"Allow changing the type of existing hydrants"`
+		Area            string   `flag:"area" default:"" description:"GeoJSON or .poly file with the area to edit hydrants in, waypoints and hydrants outside are skipped"`
+		AutoApply       bool     `flag:"auto-apply" default:"false" description:"Send the changes of new files found by the watch command instead of only writing a report"`
+		BBox            string   `flag:"bbox" default:"" description:"Area for analyze commands (min_lon,min_lat,max_lon,max_lat), taken from gpx-file or area if empty"`
+		CaptureFixes    int64    `flag:"capture-fixes" default:"5" description:"Number of NMEA fixes to average for each captured waypoint"`
+		CautionRange    int64    `flag:"caution-range" default:"25" description:"Range of meters in which an existing hydrant holds back creating a new one for review (0 = disabled)"`
+		CheckDateAge    int64    `flag:"check-date-age" default:"365" description:"Minimum age in days of the survey date of unchanged hydrants before it is updated"`
+		CheckDateTag    string   `flag:"check-date-tag" default:"" description:"Tag to store the survey date from the waypoint time in (check_date, survey:date), disabled if empty"`
+		ClusterRange    int64    `flag:"cluster-range" default:"0" description:"Range of meters to merge repeated GPX fixes of the same hydrant (0 = disabled)"`
+		CodeFields      []string `flag:"code-fields" default:"cmt" description:"Waypoint fields to search for the hydrant code in order of priority (cmt, name, desc, sym, type)"`
+		Comment         string   `flag:"comment,c" default:"Added hydrants from GPX file" description:"Comment for the changeset"`
+		CorridorWidth   int64    `flag:"corridor-width" default:"20" description:"Distance in meters from the GPX tracks in which all hydrants are expected to be surveyed (analyze unconfirmed)"`
+		CoverageFile    string   `flag:"coverage-file" default:"" description:"Write the street segments not covered by hydrants as GeoJSON (analyze coverage)"`
+		CoverageRange   int64    `flag:"coverage-range" default:"75" description:"Maximum distance in meters of streets to the nearest hydrant (analyze coverage)"`
+		CSVMapping      string   `flag:"csv-mapping" default:"" description:"YAML file describing the columns of a CSV input file"`
+		Debug           bool     `flag:"debug,d" default:"false" description:"Enable debug logging (Deprecated: Use --log-level=debug)"`
+		DuplicateRange  int64    `flag:"duplicate-range" default:"2" description:"Range of meters in which OSM hydrants are reported as duplicates"`
+		ForceCreate     bool     `flag:"force-create" default:"false" description:"Create hydrants even if an existing hydrant is within caution-range"`
+		ForceCreateFor  []string `flag:"force-create-for" default:"" description:"Waypoint names to create even if an existing hydrant is within caution-range"`
+		GPXFile         string   `flag:"gpx-file,f" description:"File containing GPX waypoints"`
+		InputFormat     string   `flag:"input-format" default:"" description:"Format of the gpx-file (gpx, kml, kmz, geojson, csv), detected by file extension if empty"`
+		Interactive     bool     `flag:"interactive,i" default:"false" description:"Review every change in the terminal before sending it"`
+		JournalFile     string   `flag:"journal-file" default:"" description:"File recording every successful write to skip them when running again"`
+		Lenient         bool     `flag:"lenient" default:"false" description:"Accept lower case hydrant codes containing separators (su 100, S-U-100)"`
+		Listen          string   `flag:"listen" default:"127.0.0.1:3000" description:"Address to listen on for the serve and server commands"`
+		LogLevel        string   `flag:"log-level" default:"info" description:"Log level (debug, info, warn, error)"`
+		MachRange       int64    `flag:"match-range" default:"5" description:"Range of meters to match GPX hydrants to OSM nodes"`
+		NMEASource      string   `flag:"nmea-source" default:"" description:"NMEA source for capture: file, device path, tcp://host:port or gpsd://host:port"`
+		NoOp            bool     `flag:"noop,n" default:"false" description:"Fetch data from OSM but do not write"`
+		OsmChangeFile   string   `flag:"osmchange-file" default:"" description:"Write an osmChange file merging duplicate hydrants (analyze duplicates)"`
+		OutputFile      string   `flag:"output-file,o" default:"" description:"Write resulting hydrants to this file"`
+		OutputFormat    string   `flag:"output-format" default:"" description:"Format of the output-file (kml, kmz, geojson), detected by file extension if empty"`
+		OSM             struct {
 			APIURL   string `flag:"osm-apiurl" default:"https://api.openstreetmap.org/api/0.6" description:"API base url to contact"`
 			Username string `flag:"osm-user" description:"Username to log into OSM"`
 			Password string `flag:"osm-pass" description:"Password for osm-user"`
 			UseDev   bool   `flag:"osm-dev" default:"false" description:"Switch to dev API (Deprecated: Use --osm-apiurl)"`
 		}
-		Pressure       int64 `flag:"pressure" default:"4" description:"Pressure of the water grid"`
-		VersionAndExit bool  `flag:"version" default:"false" description:"Print version and exit"`
+		PlanFile        string        `flag:"plan-file" default:"" description:"JSON file to write the plan to (plan) or read the plan from (apply)"`
+		PolicyFile      string        `flag:"policy-file" default:"" description:"YAML file with rules which fields a survey may overwrite"`
+		Pressure        int64         `flag:"pressure" default:"4" description:"Pressure of the water grid, overridden by zones"`
+		Rebase          bool          `flag:"rebase" default:"false" description:"Apply a plan to nodes changed since planning if the changes don't conflict"`
+		ReportDir       string        `flag:"report-dir" default:"" description:"Directory to write the reports of the watch command to"`
+		ReportFile      string        `flag:"report-file" default:"" description:"Write a report of all waypoints and their outcome to this file"`
+		ReportFormat    string        `flag:"report-format" default:"" description:"Format of the report-file (json, csv, html), detected by file extension if empty"`
+		RemovalMode     string        `flag:"removal-mode" default:"disused" description:"How to handle hydrants marked as removed (disused, removed, delete)"`
+		ServerDir       string        `flag:"server-dir" default:"gpxhydrant-server" description:"Directory to store submitted files, plans and the audit log of the server command"`
+		ServerKeys      string        `flag:"server-keys" default:"" description:"YAML file mapping user names to their API keys for the server command"`
+		Strict          bool          `flag:"strict" default:"false" description:"Fail if any waypoint could not be converted into a hydrant"`
+		SymbolTypes     string        `flag:"symbol-types" default:"" description:"Map waypoint symbols to hydrant types (Format: 'Flag, Blue=U;Flag, Red=O')"`
+		TileAttribution string        `flag:"tile-attribution" default:"© OpenStreetMap contributors" description:"Attribution shown for the map tiles in the serve command"`
+		TileURL         string        `flag:"tile-url" default:"https://tile.openstreetmap.org/{z}/{x}/{y}.png" description:"Tile URL template for the serve command, draws outlines of the OSM data if empty"`
+		UnconfirmedFile string        `flag:"unconfirmed-file" default:"" description:"Write the hydrants not confirmed by a waypoint as GPX waypoints (analyze unconfirmed)"`
+		Verify          bool          `flag:"verify" default:"false" description:"Download all sent nodes after the upload and report differences to the sent data"`
+		VersionAndExit  bool          `flag:"version" default:"false" description:"Print version and exit"`
+		WatchInterval   time.Duration `flag:"watch-interval" default:"10s" description:"Interval to scan the directory of the watch command for new files"`
+		WatchState      string        `flag:"watch-state" default:"gpxhydrant-watch.json" description:"File recording the checksums of the files processed by the watch command"`
+		ZonesFile       string        `flag:"zones-file" default:"" description:"YAML file with areas setting default operator, pressure, water source and ref prefix of hydrants"`
 	}{}
 	version = "dev"
 
@@ -37,6 +85,10 @@ var (
 	errWrongGPXComment = errors.New("GPX comment does not match expected format")
 )
 
+// mapDataBorder is the width in meters of the border retrieved around
+// the bounds to find hydrants matching waypoints at the edges
+const mapDataBorder = 100.0
+
 type bounds struct{ MinLat, MinLon, MaxLat, MaxLon float64 }
 
 func (b *bounds) Update(lat, lon float64) {
@@ -73,12 +125,19 @@ func init() {
 		log.SetLevel(log.DebugLevel)
 	}
 
-	if cfg.GPXFile == "" {
-		log.Fatalf("gpx-file is a required parameter")
+	var err error
+	if symbolTypes, err = parseSymbolTypes(cfg.SymbolTypes); err != nil {
+		log.Fatalf("Unable to parse symbol types: %s", err)
 	}
 
-	if cfg.OSM.Password == "" || cfg.OSM.Username == "" {
-		log.Fatalf("osm-pass / osm-user are required parameters")
+	if cfg.Area != "" {
+		if surveyArea, err = loadArea(cfg.Area); err != nil {
+			log.Fatalf("Unable to load area: %s", err)
+		}
+	}
+
+	if hydrantZones, err = loadZones(cfg.ZonesFile); err != nil {
+		log.Fatalf("Unable to load zones: %s", err)
 	}
 
 	if cfg.OSM.UseDev {
@@ -87,47 +146,74 @@ func init() {
 	}
 }
 
-func hydrantsFromGPXFile() ([]*hydrant, bounds) {
-	// Read and parse GPX file
-	gpsFile, err := os.Open(cfg.GPXFile)
+func hydrantsFromGPXFile() ([]*hydrant, []skippedWaypoint, bounds) {
+	hydrants, skipped, bds, err := readHydrants(cfg.GPXFile, cfg.InputFormat)
 	if err != nil {
-		log.Fatalf("Unable to open your GPX file: %s", err)
+		log.Fatalf("Unable to read your GPX file: %s", err)
 	}
-	defer gpsFile.Close()
 
-	gpxData, err := gpx.ParseGPXData(gpsFile)
+	return hydrants, skipped, bds
+}
+
+// readHydrants converts the waypoints of the file into hydrants and
+// returns them with the skipped waypoints and the bounds of the hydrants
+func readHydrants(filename, format string) ([]*hydrant, []skippedWaypoint, bounds, error) {
+	bds := bounds{MinLat: 9999, MinLon: 9999}
+
+	// Read and parse GPX file
+	gpxData, err := readWaypoints(filename, format)
 	if err != nil {
-		log.Fatalf("Unable to parse your GPX file: %s", err)
+		return nil, nil, bds, err
 	}
 
-	bds := bounds{MinLat: 9999, MinLon: 9999}
 	hydrants := []*hydrant{}
+	skipped := []skippedWaypoint{}
 
 	for _, wp := range gpxData.Waypoints {
+		if !surveyArea.Contains(wp.Latitude, wp.Longitude) {
+			skipped = append(skipped, newSkippedWaypoint(wp, errOutsideArea))
+			continue
+		}
+
 		h, e := parseWaypoint(wp)
 		if e != nil {
-			if e != errWrongGPXComment {
-				log.Debugf("Found waypoint not suitable for converting: %s (Reason: %s)", wp.Name, e)
-			}
+			skipped = append(skipped, newSkippedWaypoint(wp, e))
 			continue
 		}
-		log.Debugf("Found a hydrant from waypoint %s: %#v", wp.Name, h)
+		log.Debugf("Found a hydrant from waypoint %s (code in %s): %#v", wp.Name, h.CodeSource, h)
 		hydrants = append(hydrants, h)
+	}
 
+	logSkippedWaypoints(skipped, len(hydrants))
+
+	hydrants = clusterHydrants(hydrants, float64(cfg.ClusterRange))
+
+	for _, h := range hydrants {
 		bds.Update(h.Latitude, h.Longitude)
 	}
 
-	return hydrants, bds
+	return hydrants, skipped, bds, nil
 }
 
 func createChangeset(osmClient *osm.Client) *osm.Changeset {
+	cs, err := openChangeset(osmClient)
+	if err != nil {
+		log.Fatalf("%s", err)
+	}
+
+	return cs
+}
+
+// openChangeset returns the changeset of the current run and creates it
+// on first use
+func openChangeset(osmClient *osm.Client) (*osm.Changeset, error) {
 	if changeset != nil {
-		return changeset
+		return changeset, nil
 	}
 
 	cs, err := osmClient.CreateChangeset()
 	if err != nil {
-		log.Fatalf("Unable to create changeset: %s", err)
+		return nil, fmt.Errorf("Unable to create changeset: %s", err)
 	}
 
 	log.Debugf("Working on Changeset %d", cs.ID)
@@ -138,23 +224,52 @@ func createChangeset(osmClient *osm.Client) *osm.Changeset {
 	}
 
 	if err := osmClient.SaveChangeset(cs); err != nil {
-		log.Fatalf("Unable to save changeset: %s", err)
+		return nil, fmt.Errorf("Unable to save changeset: %s", err)
 	}
 
 	changeset = cs
 
-	return cs
+	return cs, nil
 }
 
 func getHydrantsFromOSM(osmClient *osm.Client, bds bounds) []*hydrant {
-	border := 0.0009 // Equals ~100m using haversine formula
-	mapData, err := osmClient.RetrieveMapObjects(bds.MinLon-border, bds.MinLat-border, bds.MaxLon+border, bds.MaxLat+border)
+	return hydrantsFromMapData(retrieveMapData(osmClient, bds, mapDataBorder))
+}
+
+// retrieveMapData gets all objects within the bounds and a border of
+// the given width in meters around them
+func retrieveMapData(osmClient *osm.Client, bds bounds, border float64) *osm.Wrap {
+	mapData, err := fetchMapData(osmClient, bds, border)
 	if err != nil {
 		log.Fatalf("Unable to get map data: %s", err)
 	}
 
-	log.Debugf("Retrieved %d nodes from map", len(mapData.Nodes))
+	return mapData
+}
 
+func fetchMapData(osmClient *osm.Client, bds bounds, border float64) (*osm.Wrap, error) {
+	d := 0.0009 * border / 100 // 0.0009 equals ~100m using haversine formula
+
+	bds, err := surveyArea.Clip(bds)
+	if err != nil {
+		return nil, err
+	}
+	bds = bounds{MinLat: bds.MinLat - d, MinLon: bds.MinLon - d, MaxLat: bds.MaxLat + d, MaxLon: bds.MaxLon + d}
+
+	mapData, err := osmClient.RetrieveMapObjects(bds.MinLon, bds.MinLat, bds.MaxLon, bds.MaxLat)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Debugf("Retrieved %d nodes and %d ways from map", len(mapData.Nodes), len(mapData.Ways))
+
+	return mapData, nil
+}
+
+// hydrantsFromMapData returns all hydrants of the map data including the
+// ones outside of the area, they are needed to match waypoints at the
+// edge of the area but must not be edited
+func hydrantsFromMapData(mapData *osm.Wrap) []*hydrant {
 	availableHydrants := []*hydrant{}
 	for _, n := range mapData.Nodes {
 		h, e := fromNode(n)
@@ -169,8 +284,49 @@ func getHydrantsFromOSM(osmClient *osm.Client, bds bounds) []*hydrant {
 }
 
 func main() {
-	// Convert waypoints from GPX file to hydrants
-	hydrants, bds := hydrantsFromGPXFile()
+	args := commandArgs()
+	if len(args) == 0 {
+		runImport()
+		return
+	}
+
+	switch args[0] {
+	case "analyze":
+		runAnalyze(args[1:])
+	case "apply":
+		runApply()
+	case "capture":
+		runCapture()
+	case "plan":
+		runPlan()
+	case "revert":
+		runRevert(args[1:])
+	case "serve":
+		runServe()
+	case "server":
+		runServer()
+	case "watch":
+		runWatch(args[1:])
+	default:
+		log.Fatalf("Unknown command %q", args[0])
+	}
+}
+
+// commandArgs returns the positional arguments without the program name
+func commandArgs() []string {
+	return rconfig.Args()[1:]
+}
+
+func requireGPXFile() {
+	if cfg.GPXFile == "" {
+		log.Fatalf("gpx-file is a required parameter")
+	}
+}
+
+func newOSMClient() *osm.Client {
+	if cfg.OSM.Password == "" || cfg.OSM.Username == "" {
+		log.Fatalf("osm-pass / osm-user are required parameters")
+	}
 
 	osmClient, err := osm.NewWithAPIEndpoint(cfg.OSM.Username, cfg.OSM.Password, cfg.OSM.APIURL)
 	if err != nil {
@@ -179,59 +335,105 @@ func main() {
 
 	osmClient.DebugHTTPRequests = log.GetLevel() == log.DebugLevel
 
-	// Retrieve currently available information from OSM
-	availableHydrants := getHydrantsFromOSM(osmClient, bds)
+	return osmClient
+}
 
-	updateOrCreateHydrants(hydrants, availableHydrants, osmClient)
+func runImport() {
+	changes, skipped, osmClient := planImport()
+	applyAndReport(changes, skipped, osmClient)
 }
 
-func updateOrCreateHydrants(hydrants, availableHydrants []*hydrant, osmClient *osm.Client) {
-	for _, h := range hydrants {
-		var found *hydrant
-		for _, a := range availableHydrants {
-			dist := position.Haversine(h.Longitude, h.Latitude, a.Longitude, a.Latitude)
-			if dist <= float64(cfg.MachRange)/1000.0 {
-				found = a
-			}
-		}
+// applyAndReport sends the changes, writes the output file and the
+// report, optionally verifies the sent nodes and exits with an error
+// summary if any of the changes failed
+func applyAndReport(changes []*plannedChange, skipped []skippedWaypoint, osmClient *osm.Client) {
+	j, err := openJournal(cfg.JournalFile)
+	if err != nil {
+		log.Fatalf("Unable to read journal: %s", err)
+	}
 
-		if found == nil {
-			// No matched hydrant: Lets create one
-			doNoOp(
-				fmt.Sprintf("[NOOP] Would send a create to OSM (Changeset %d): %#v", createChangeset(osmClient).ID, h.ToNode()),
-				func() {
-					if err := osmClient.SaveNode(h.ToNode(), createChangeset(osmClient)); err != nil {
-						log.Fatalf("Unable to create node using the OSM API: %s", err)
-					}
-					log.Debugf("Created a hydrant: %s", h.Name)
-				},
-			)
-			continue
+	result, errs := applyChanges(changes, osmClient, j)
+
+	if cfg.OutputFile != "" {
+		if err := writeHydrants(cfg.OutputFile, cfg.OutputFormat, result); err != nil {
+			log.Fatalf("Unable to write output file: %s", err)
 		}
+	}
 
-		// Special case: If the diameter of the recorded hydrant is unknown but previously known keep the previous version
-		if h.Diameter == 0 && found.Diameter > 0 {
-			h.Diameter = found.Diameter
+	verified := true
+	if cfg.Verify && !cfg.NoOp {
+		verified = runVerification(os.Stdout, changes, osmClient)
+	}
+
+	report := newRunReport(changes, skipped)
+	if cfg.ReportFile != "" {
+		if err := writeRunReport(cfg.ReportFile, cfg.ReportFormat, report); err != nil {
+			log.Fatalf("Unable to write report: %s", err)
 		}
+	}
 
-		if !found.NeedsUpdate(h) {
-			log.Debugf("Found a good looking hydrant which needs no update: %#v", h)
-			// Everything matches, we don't care
-			continue
+	if len(errs) > 0 {
+		for _, e := range errs {
+			log.Errorf("Failed: %s", e)
 		}
+		log.Fatalf("%d changes failed, run again to retry them", len(errs))
+	}
+
+	if !verified {
+		log.Fatalf("Verification of the sent nodes failed")
+	}
+
+	if w := report.Warnings(); w > 0 {
+		log.Warnf("Completed with %d skipped waypoints or waypoints needing review", w)
+		os.Exit(exitCodeWarnings)
+	}
+}
+
+// planImport reads the hydrants from the gpx-file, retrieves the hydrants
+// available in OSM and plans the changes to send
+func planImport() ([]*plannedChange, []skippedWaypoint, *osm.Client) {
+	requireGPXFile()
+	validateRemovalMode()
+
+	// Convert waypoints from GPX file to hydrants
+	hydrants, skipped, bds := hydrantsFromGPXFile()
 
-		h.ID = found.ID
-		h.Version = found.Version
-		doNoOp(
-			fmt.Sprintf("[NOOP] Would send a change to OSM (Changeset %d): To=%#v From=%#v", createChangeset(osmClient).ID, h.ToNode(), found.ToNode()),
-			func() {
-				if err := osmClient.SaveNode(h.ToNode(), createChangeset(osmClient)); err != nil {
-					log.Fatalf("Unable to create node using the OSM API: %s", err)
-				}
-				log.Debugf("Changed a hydrant: %s", h.Name)
-			},
-		)
+	if err := checkStrict(skipped); err != nil {
+		log.Fatalf("Aborting: %s", err)
 	}
+
+	osmClient := newOSMClient()
+
+	// Retrieve currently available information from OSM
+	availableHydrants := getHydrantsFromOSM(osmClient, bds)
+
+	return planHydrants(hydrants, availableHydrants, osmClient), skipped, osmClient
+}
+
+// planHydrants matches the hydrants against the hydrants available in
+// OSM and returns the changes after an optional interactive review
+func planHydrants(hydrants, availableHydrants []*hydrant, osmClient *osm.Client) []*plannedChange {
+	policy, err := loadUpdatePolicy(cfg.PolicyFile)
+	if err != nil {
+		log.Fatalf("Unable to load update policy: %s", err)
+	}
+
+	changes, err := planChanges(hydrants, availableHydrants, policy, osmClient.CurrentUser.ID)
+	if err != nil {
+		log.Fatalf("Unable to plan changes: %s", err)
+	}
+
+	for _, c := range changes {
+		logPolicyDecisions(c.Decisions)
+	}
+
+	if cfg.Interactive {
+		if err := reviewChanges(os.Stdin, os.Stdout, changes, availableHydrants, policy, osmClient.CurrentUser.ID); err != nil {
+			log.Fatalf("Unable to read review input: %s", err)
+		}
+	}
+
+	return changes
 }
 
 func doNoOp(message string, execution func()) {
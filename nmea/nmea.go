@@ -0,0 +1,360 @@
+// Package nmea parses the NMEA 0183 sentences GGA, RMC and GSA as sent
+// by GPS receivers and combines them into position fixes.
+package nmea
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrUnsupportedSentence is returned for valid sentences of a type not
+// handled by this package
+var ErrUnsupportedSentence = errors.New("Unsupported NMEA sentence")
+
+// GGA contains the Global Positioning System Fix Data
+type GGA struct {
+	TimeOfDay  time.Duration
+	Latitude   float64
+	Longitude  float64
+	Quality    int64
+	Satellites int64
+	HDOP       float64
+	Altitude   float64
+}
+
+// RMC contains the Recommended Minimum Specific GNSS Data
+type RMC struct {
+	Time      time.Time
+	Valid     bool
+	Latitude  float64
+	Longitude float64
+}
+
+// GSA contains the GNSS DOP and Active Satellites
+type GSA struct {
+	FixMode int64
+	PDOP    float64
+	HDOP    float64
+	VDOP    float64
+}
+
+// ParseSentence validates the checksum of the sentence and returns a
+// GGA, RMC or GSA depending on the sentence type
+func ParseSentence(line string) (interface{}, error) {
+	line = strings.TrimSpace(line)
+	if !strings.HasPrefix(line, "$") {
+		return nil, fmt.Errorf("Sentence does not start with $: %q", line)
+	}
+
+	data := line[1:]
+	if idx := strings.LastIndex(data, "*"); idx >= 0 {
+		sum, err := strconv.ParseUint(data[idx+1:], 16, 8)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid checksum in %q", line)
+		}
+		data = data[:idx]
+
+		var calc byte
+		for i := 0; i < len(data); i++ {
+			calc ^= data[i]
+		}
+		if byte(sum) != calc {
+			return nil, fmt.Errorf("Checksum mismatch in %q", line)
+		}
+	}
+
+	fields := strings.Split(data, ",")
+	if len(fields[0]) != 5 {
+		return nil, fmt.Errorf("Invalid sentence type in %q", line)
+	}
+
+	// First two characters are the talker ID (GP, GN, GL, ...)
+	switch fields[0][2:] {
+	case "GGA":
+		return parseGGA(fields)
+	case "RMC":
+		return parseRMC(fields)
+	case "GSA":
+		return parseGSA(fields)
+	default:
+		return nil, ErrUnsupportedSentence
+	}
+}
+
+func parseGGA(f []string) (GGA, error) {
+	out := GGA{}
+	if len(f) < 10 {
+		return out, errors.New("GGA sentence too short")
+	}
+
+	var err error
+	if out.TimeOfDay, err = parseTimeOfDay(f[1]); err != nil {
+		return out, err
+	}
+	if out.Quality, err = parseInt(f[6]); err != nil {
+		return out, err
+	}
+	if out.Quality == 0 {
+		// No fix, position fields are empty
+		return out, nil
+	}
+	if out.Latitude, err = parseCoordinate(f[2], f[3]); err != nil {
+		return out, err
+	}
+	if out.Longitude, err = parseCoordinate(f[4], f[5]); err != nil {
+		return out, err
+	}
+	if out.Satellites, err = parseInt(f[7]); err != nil {
+		return out, err
+	}
+	if out.HDOP, err = parseFloat(f[8]); err != nil {
+		return out, err
+	}
+	if out.Altitude, err = parseFloat(f[9]); err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+func parseRMC(f []string) (RMC, error) {
+	out := RMC{}
+	if len(f) < 10 {
+		return out, errors.New("RMC sentence too short")
+	}
+
+	out.Valid = f[2] == "A"
+
+	tod, err := parseTimeOfDay(f[1])
+	if err != nil {
+		return out, err
+	}
+	if f[9] != "" {
+		date, err := time.Parse("020106", f[9])
+		if err != nil {
+			return out, err
+		}
+		out.Time = date.Add(tod)
+	}
+
+	if !out.Valid {
+		return out, nil
+	}
+
+	if out.Latitude, err = parseCoordinate(f[3], f[4]); err != nil {
+		return out, err
+	}
+	if out.Longitude, err = parseCoordinate(f[5], f[6]); err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+func parseGSA(f []string) (GSA, error) {
+	out := GSA{}
+	if len(f) < 18 {
+		return out, errors.New("GSA sentence too short")
+	}
+
+	var err error
+	if out.FixMode, err = parseInt(f[2]); err != nil {
+		return out, err
+	}
+	if out.PDOP, err = parseFloat(f[15]); err != nil {
+		return out, err
+	}
+	if out.HDOP, err = parseFloat(f[16]); err != nil {
+		return out, err
+	}
+	if out.VDOP, err = parseFloat(f[17]); err != nil {
+		return out, err
+	}
+
+	return out, nil
+}
+
+func parseTimeOfDay(v string) (time.Duration, error) {
+	if len(v) < 6 {
+		return 0, fmt.Errorf("Invalid time %q", v)
+	}
+
+	h, err := strconv.Atoi(v[0:2])
+	if err != nil {
+		return 0, err
+	}
+	m, err := strconv.Atoi(v[2:4])
+	if err != nil {
+		return 0, err
+	}
+	s, err := strconv.ParseFloat(v[4:], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute + time.Duration(s*float64(time.Second)), nil
+}
+
+// parseCoordinate converts the NMEA format (d)ddmm.mmmm with hemisphere
+// into decimal degrees
+func parseCoordinate(v, hemisphere string) (float64, error) {
+	idx := strings.Index(v, ".")
+	if idx < 3 {
+		return 0, fmt.Errorf("Invalid coordinate %q", v)
+	}
+
+	deg, err := strconv.ParseFloat(v[:idx-2], 64)
+	if err != nil {
+		return 0, err
+	}
+	min, err := strconv.ParseFloat(v[idx-2:], 64)
+	if err != nil {
+		return 0, err
+	}
+
+	out := deg + min/60
+	if hemisphere == "S" || hemisphere == "W" {
+		out = -out
+	}
+
+	return out, nil
+}
+
+func parseInt(v string) (int64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseInt(v, 10, 64)
+}
+
+func parseFloat(v string) (float64, error) {
+	if v == "" {
+		return 0, nil
+	}
+	return strconv.ParseFloat(v, 64)
+}
+
+// Fix represents a valid position reported by the receiver. The time is
+// zero until the receiver sent the date in a RMC sentence.
+type Fix struct {
+	Time       time.Time
+	Latitude   float64
+	Longitude  float64
+	Elevation  float64
+	HDOP       float64
+	Satellites int64
+}
+
+// Reader reads NMEA sentences from a stream and combines them into fixes
+type Reader struct {
+	scanner *bufio.Scanner
+
+	date    time.Time
+	rmcSeen bool
+	rmcOK   bool
+	gsaSeen bool
+	gsaMode int64
+	gsaHDOP float64
+}
+
+// NewReader creates a Reader on top of the stream
+func NewReader(in io.Reader) *Reader {
+	return &Reader{scanner: bufio.NewScanner(in)}
+}
+
+// Next returns the next valid fix. A fix is emitted for every GGA
+// sentence with a position, RMC and GSA sentences are used to add the
+// date and to discard fixes the receiver marked as invalid. Sentences
+// failing to parse are skipped. At the end of the stream io.EOF is
+// returned.
+func (r *Reader) Next() (Fix, error) {
+	for r.scanner.Scan() {
+		s, err := ParseSentence(r.scanner.Text())
+		if err != nil {
+			continue
+		}
+
+		switch v := s.(type) {
+		case RMC:
+			r.rmcSeen, r.rmcOK = true, v.Valid
+			if !v.Time.IsZero() {
+				r.date = v.Time.Truncate(24 * time.Hour)
+			}
+
+		case GSA:
+			r.gsaSeen, r.gsaMode, r.gsaHDOP = true, v.FixMode, v.HDOP
+
+		case GGA:
+			if v.Quality == 0 || (r.rmcSeen && !r.rmcOK) || (r.gsaSeen && r.gsaMode < 2) {
+				continue
+			}
+
+			fix := Fix{
+				Latitude:   v.Latitude,
+				Longitude:  v.Longitude,
+				Elevation:  v.Altitude,
+				HDOP:       v.HDOP,
+				Satellites: v.Satellites,
+			}
+			// Without date the time is left empty to get the same fixes
+			// when replaying a log
+			if !r.date.IsZero() {
+				fix.Time = r.date.Add(v.TimeOfDay)
+			}
+			if fix.HDOP == 0 && r.gsaSeen {
+				fix.HDOP = r.gsaHDOP
+			}
+
+			return fix, nil
+		}
+	}
+
+	if err := r.scanner.Err(); err != nil {
+		return Fix{}, err
+	}
+	return Fix{}, io.EOF
+}
+
+// Open opens a NMEA source: "tcp://host:port" connects to a raw NMEA
+// stream, "gpsd://host:port" connects to gpsd and enables its NMEA
+// output, everything else is opened as a file or device path. live is
+// false for regular files which can be replayed.
+func Open(source string) (rc io.ReadCloser, live bool, err error) {
+	switch {
+	case strings.HasPrefix(source, "tcp://"):
+		conn, err := net.Dial("tcp", strings.TrimPrefix(source, "tcp://"))
+		return conn, true, err
+
+	case strings.HasPrefix(source, "gpsd://"):
+		conn, err := net.Dial("tcp", strings.TrimPrefix(source, "gpsd://"))
+		if err != nil {
+			return nil, true, err
+		}
+		if _, err := io.WriteString(conn, `?WATCH={"enable":true,"nmea":true};`+"\n"); err != nil {
+			conn.Close()
+			return nil, true, err
+		}
+		return conn, true, nil
+
+	default:
+		f, err := os.Open(source)
+		if err != nil {
+			return nil, false, err
+		}
+
+		stat, err := f.Stat()
+		if err != nil {
+			f.Close()
+			return nil, false, err
+		}
+
+		return f, !stat.Mode().IsRegular(), nil
+	}
+}
@@ -0,0 +1,89 @@
+package nmea
+
+import (
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testLog = `$GPRMC,123400.00,A,5335.0711,N,00943.6793,E,0.0,0.0,060516,,,A*5C
+$GPGGA,123400.00,5335.0710,N,00943.6793,E,1,08,0.9,23.4,M,46.9,M,,*54
+$GPGSA,A,3,01,02,03,04,05,06,07,08,,,,,1.8,0.9,1.5*3E
+$GPRMC,123401.00,A,5335.0711,N,00943.6793,E,0.0,0.0,060516,,,A*5D
+$GPGGA,123401.00,5335.0711,N,00943.6793,E,1,08,1.0,23.4,M,46.9,M,,*5C
+`
+
+func TestParseSentence(t *testing.T) {
+	for _, c := range []struct {
+		Line    string
+		Valid   bool
+		Quality int64
+	}{
+		{"$GPGGA,123400.00,5335.0710,N,00943.6793,E,1,08,0.9,23.4,M,46.9,M,,*54", true, 1},
+		{"$GNGGA,123400.00,5335.0710,N,00943.6793,E,2,08,0.9,23.4,M,46.9,M,,", true, 2},
+		{"$GPGGA,123400.00,5335.0710,N,00943.6793,E,1,08,0.9,23.4,M,46.9,M,,*55", false, 0},
+		{"GPGGA,123400.00,5335.0710,N,00943.6793,E,1,08,0.9,23.4,M,46.9,M,,", false, 0},
+	} {
+		s, err := ParseSentence(c.Line)
+		if (err == nil) != c.Valid {
+			t.Errorf("%q: expected valid=%v, got error %v", c.Line, c.Valid, err)
+			continue
+		}
+		if !c.Valid {
+			continue
+		}
+
+		gga, ok := s.(GGA)
+		if !ok {
+			t.Errorf("%q: expected GGA, got %T", c.Line, s)
+			continue
+		}
+		if gga.Quality != c.Quality {
+			t.Errorf("%q: expected quality %d, got %d", c.Line, c.Quality, gga.Quality)
+		}
+		if lat, lon := 53.58451666, 9.72798833; gga.Latitude-lat > 1e-7 || lat-gga.Latitude > 1e-7 || gga.Longitude-lon > 1e-7 || lon-gga.Longitude > 1e-7 {
+			t.Errorf("%q: unexpected position %f,%f", c.Line, gga.Latitude, gga.Longitude)
+		}
+	}
+}
+
+func TestReaderReplay(t *testing.T) {
+	read := func(in string) []Fix {
+		r := NewReader(strings.NewReader(in))
+		fixes := []Fix{}
+		for {
+			f, err := r.Next()
+			if err == io.EOF {
+				return fixes
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %s", err)
+			}
+			fixes = append(fixes, f)
+		}
+	}
+
+	fixes := read(testLog)
+	if len(fixes) != 2 {
+		t.Fatalf("Expected 2 fixes, got %d", len(fixes))
+	}
+
+	if exp := time.Date(2016, 5, 6, 12, 34, 1, 0, time.UTC); !fixes[1].Time.Equal(exp) {
+		t.Errorf("Expected time %s, got %s", exp, fixes[1].Time)
+	}
+
+	// Replaying the same log has to give the same fixes
+	again := read(testLog)
+	for i := range fixes {
+		if fixes[i] != again[i] {
+			t.Errorf("Fix %d differs on replay: %#v != %#v", i, fixes[i], again[i])
+		}
+	}
+
+	// Without RMC sentence there is no date to take the time from
+	noDate := read("$GPGGA,123400.00,5335.0710,N,00943.6793,E,1,08,0.9,23.4,M,46.9,M,,*54\n")
+	if len(noDate) != 1 || !noDate[0].Time.IsZero() {
+		t.Errorf("Expected one fix without time, got %#v", noDate)
+	}
+}
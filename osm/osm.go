@@ -10,6 +10,7 @@ import (
 	"math"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -18,6 +19,28 @@ const (
 	devAPIBaseURL  = "https://api06.dev.openstreetmap.org/api/0.6"
 )
 
+// APIError is returned when the API responds with a status code other than 200
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("OSM API responded with status code %d", e.StatusCode)
+	}
+	return fmt.Sprintf("OSM API responded with status code %d: %s", e.StatusCode, e.Message)
+}
+
+// GoneError is returned when requesting a node which has been deleted
+type GoneError struct {
+	ID int64
+}
+
+func (e GoneError) Error() string {
+	return fmt.Sprintf("Node %d has been deleted", e.ID)
+}
+
 // Client represents an OSM client which is capable of RW operations on the OpenStreetMap
 type Client struct {
 	username string
@@ -131,7 +154,7 @@ func (c *Client) do(method, path string, body io.Reader) (io.ReadCloser, error)
 	}
 
 	if res.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("OSM API responded with status code %d", res.StatusCode)
+		return nil, &APIError{StatusCode: res.StatusCode, Message: strings.TrimSpace(resBody.String())}
 	}
 
 	return ioutil.NopCloser(resBody), nil
@@ -158,6 +181,7 @@ type Wrap struct {
 	User       *User        `xml:"user,omitempty"`
 	Changesets []*Changeset `xml:"changeset,omitempty"`
 	Nodes      []*Node      `xml:"node,omitempty"`
+	Ways       []*Way       `xml:"way,omitempty"`
 }
 
 // Changeset contains information about a changeset in the API. You need to create a changeset before submitting any changes to the API.
@@ -237,6 +261,17 @@ func (c *Client) SaveChangeset(cs *Changeset) error {
 	return err
 }
 
+// DownloadChangeset retrieves all changes made in the changeset
+func (c *Client) DownloadChangeset(id int64) (*OsmChange, error) {
+	responseBody, err := c.do("GET", fmt.Sprintf("/changeset/%d/download", id), nil)
+	if err != nil {
+		return nil, err
+	}
+	defer responseBody.Close()
+
+	return ParseOsmChange(responseBody)
+}
+
 // RetrieveMapObjects queries all objects within the passed bounds. You need to ensure the min values are below the max values.
 func (c *Client) RetrieveMapObjects(minLat, minLon, maxLat, maxLon float64) (*Wrap, error) {
 	urlPath := fmt.Sprintf("/map?bbox=%.7f,%.7f,%.7f,%.7f", minLat, minLon, maxLat, maxLon)
@@ -256,19 +291,68 @@ type User struct {
 
 // Node represents one node in the OpenStreetMap
 type Node struct {
-	XMLName   xml.Name `xml:"node"`
-	ID        int64    `xml:"id,attr,omitempty"`
-	Version   int64    `xml:"version,attr,omitempty"`
-	Changeset int64    `xml:"changeset,attr,omitempty"`
-	User      string   `xml:"user,attr,omitempty"`
-	UID       int64    `xml:"uid,attr,omitempty"`
-	Latitude  float64  `xml:"lat,attr"`
-	Longitude float64  `xml:"lon,attr"`
+	XMLName   xml.Name   `xml:"node"`
+	ID        int64      `xml:"id,attr,omitempty"`
+	Version   int64      `xml:"version,attr,omitempty"`
+	Changeset int64      `xml:"changeset,attr,omitempty"`
+	User      string     `xml:"user,attr,omitempty"`
+	UID       int64      `xml:"uid,attr,omitempty"`
+	Timestamp *time.Time `xml:"timestamp,attr,omitempty"`
+	Visible   bool       `xml:"visible,attr,omitempty"`
+	Latitude  float64    `xml:"lat,attr"`
+	Longitude float64    `xml:"lon,attr"`
 
 	Tags []Tag `xml:"tag"`
 }
 
+// GetNode retrieves the current version of a node. If the node has been deleted a GoneError is returned.
+func (c *Client) GetNode(id int64) (*Node, error) {
+	res := &Wrap{}
+	if err := c.doParse("GET", fmt.Sprintf("/node/%d", id), nil, res); err != nil {
+		if e, ok := err.(*APIError); ok && e.StatusCode == http.StatusGone {
+			return nil, &GoneError{ID: id}
+		}
+		return nil, err
+	}
+
+	if len(res.Nodes) != 1 {
+		return nil, fmt.Errorf("Unable to retrieve node %d", id)
+	}
+
+	return res.Nodes[0], nil
+}
+
+// GetNodes retrieves the current versions of multiple nodes at once. Deleted nodes are returned with Visible set to false.
+func (c *Client) GetNodes(ids []int64) ([]*Node, error) {
+	if len(ids) == 0 {
+		return []*Node{}, nil
+	}
+
+	strIDs := []string{}
+	for _, id := range ids {
+		strIDs = append(strIDs, strconv.FormatInt(id, 10))
+	}
+
+	res := &Wrap{}
+	if err := c.doParse("GET", "/nodes?nodes="+strings.Join(strIDs, ","), nil, res); err != nil {
+		return nil, err
+	}
+
+	return res.Nodes, nil
+}
+
+// GetNodeHistory retrieves all versions of a node, the oldest version first
+func (c *Client) GetNodeHistory(id int64) ([]*Node, error) {
+	res := &Wrap{}
+	if err := c.doParse("GET", fmt.Sprintf("/node/%d/history", id), nil, res); err != nil {
+		return nil, err
+	}
+
+	return res.Nodes, nil
+}
+
 // SaveNode creates or updates a node with an association to the passed changeset which needs to be open and known to the API.
+// The ID (for new nodes) and the version of the node are updated from the API response.
 func (c *Client) SaveNode(n *Node, cs *Changeset) error {
 	if n.ID > 0 && n.Version == 0 {
 		return fmt.Errorf("When an ID is set the version must be present")
@@ -293,8 +377,76 @@ func (c *Client) SaveNode(n *Node, cs *Changeset) error {
 		return err
 	}
 
-	_, err := c.doPlain("PUT", urlPath, body)
-	return err
+	res, err := c.doPlain("PUT", urlPath, body)
+	if err != nil {
+		return err
+	}
+
+	value, err := strconv.ParseInt(strings.TrimSpace(res), 10, 64)
+	if err != nil {
+		return fmt.Errorf("Unable to parse API response %q: %s", res, err)
+	}
+
+	if n.ID > 0 {
+		n.Version = value
+	} else {
+		n.ID, n.Version = value, 1
+	}
+
+	return nil
+}
+
+// DeleteNode deletes a node with an association to the passed changeset which needs to be open and known to the API.
+// The version of the node is updated from the API response.
+func (c *Client) DeleteNode(n *Node, cs *Changeset) error {
+	if n.ID <= 0 || n.Version == 0 {
+		return fmt.Errorf("To delete a node ID and version must be present")
+	}
+
+	n.Changeset = cs.ID
+
+	data := Wrap{Nodes: []*Node{n}}
+
+	body := bytes.NewBufferString(xml.Header)
+
+	enc := xml.NewEncoder(body)
+	enc.Indent("", " ")
+
+	if err := enc.Encode(data); err != nil {
+		return err
+	}
+
+	res, err := c.doPlain("DELETE", fmt.Sprintf("/node/%d", n.ID), body)
+	if err != nil {
+		return err
+	}
+
+	if n.Version, err = strconv.ParseInt(strings.TrimSpace(res), 10, 64); err != nil {
+		return fmt.Errorf("Unable to parse API response %q: %s", res, err)
+	}
+
+	return nil
+}
+
+// Way represents one way in the OpenStreetMap
+type Way struct {
+	XMLName   xml.Name   `xml:"way"`
+	ID        int64      `xml:"id,attr,omitempty"`
+	Version   int64      `xml:"version,attr,omitempty"`
+	Changeset int64      `xml:"changeset,attr,omitempty"`
+	User      string     `xml:"user,attr,omitempty"`
+	UID       int64      `xml:"uid,attr,omitempty"`
+	Timestamp *time.Time `xml:"timestamp,attr,omitempty"`
+	Visible   bool       `xml:"visible,attr,omitempty"`
+
+	NodeRefs []NodeRef `xml:"nd"`
+	Tags     []Tag     `xml:"tag"`
+}
+
+// NodeRef references a node which is part of a way
+type NodeRef struct {
+	XMLName xml.Name `xml:"nd"`
+	Ref     int64    `xml:"ref,attr"`
 }
 
 // Tag represents a key-value pair used in all objects inside OpenStreetMap
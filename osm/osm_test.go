@@ -0,0 +1,42 @@
+package osm
+
+import (
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestNodeMarshalWithoutTimestamp(t *testing.T) {
+	n := &Node{Latitude: 53.6, Longitude: 9.7, Tags: []Tag{{Key: "emergency", Value: "fire_hydrant"}}}
+
+	body, err := xml.Marshal(n)
+	if err != nil {
+		t.Fatalf("Unable to marshal node: %s", err)
+	}
+
+	if strings.Contains(string(body), "timestamp") {
+		t.Errorf("Expected no timestamp attribute, got %s", body)
+	}
+}
+
+func TestNodeTimestamp(t *testing.T) {
+	n := &Node{}
+	if err := xml.Unmarshal([]byte(`<node id="1" version="2" timestamp="2018-05-06T12:34:56Z" lat="53.6" lon="9.7"></node>`), n); err != nil {
+		t.Fatalf("Unable to unmarshal node: %s", err)
+	}
+
+	expected := time.Date(2018, 5, 6, 12, 34, 56, 0, time.UTC)
+	if n.Timestamp == nil || !n.Timestamp.Equal(expected) {
+		t.Fatalf("Expected timestamp %s, got %v", expected, n.Timestamp)
+	}
+
+	body, err := xml.Marshal(n)
+	if err != nil {
+		t.Fatalf("Unable to marshal node: %s", err)
+	}
+
+	if !strings.Contains(string(body), `timestamp="2018-05-06T12:34:56Z"`) {
+		t.Errorf("Expected timestamp attribute to be kept, got %s", body)
+	}
+}
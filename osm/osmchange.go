@@ -0,0 +1,64 @@
+package osm
+
+import (
+	"encoding/xml"
+	"io"
+)
+
+// OsmChange represents a document in the osmChange format as used by
+// the changeset upload and download and editors like JOSM
+type OsmChange struct {
+	XMLName   xml.Name       `xml:"osmChange"`
+	Version   string         `xml:"version,attr"`
+	Generator string         `xml:"generator,attr,omitempty"`
+	Create    []*ChangeBlock `xml:"create"`
+	Modify    []*ChangeBlock `xml:"modify"`
+	Delete    []*ChangeBlock `xml:"delete"`
+}
+
+// ChangeBlock holds the objects of one create, modify or delete block
+type ChangeBlock struct {
+	Nodes []*Node `xml:"node"`
+}
+
+// ParseOsmChange reads an osmChange document
+func ParseOsmChange(in io.Reader) (*OsmChange, error) {
+	out := &OsmChange{}
+	return out, xml.NewDecoder(in).Decode(out)
+}
+
+// NewOsmChange creates an empty osmChange document
+func NewOsmChange(generator string) *OsmChange {
+	return &OsmChange{Version: "0.6", Generator: generator}
+}
+
+// AddCreate adds nodes to be created
+func (o *OsmChange) AddCreate(nodes ...*Node) {
+	o.Create = append(o.Create, &ChangeBlock{Nodes: nodes})
+}
+
+// AddModify adds nodes to be modified
+func (o *OsmChange) AddModify(nodes ...*Node) {
+	o.Modify = append(o.Modify, &ChangeBlock{Nodes: nodes})
+}
+
+// AddDelete adds nodes to be deleted
+func (o *OsmChange) AddDelete(nodes ...*Node) {
+	o.Delete = append(o.Delete, &ChangeBlock{Nodes: nodes})
+}
+
+// Write writes the document including the XML header
+func (o *OsmChange) Write(out io.Writer) error {
+	if _, err := io.WriteString(out, xml.Header); err != nil {
+		return err
+	}
+
+	enc := xml.NewEncoder(out)
+	enc.Indent("", " ")
+	if err := enc.Encode(o); err != nil {
+		return err
+	}
+
+	_, err := io.WriteString(out, "\n")
+	return err
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/Luzifer/go_helpers/position"
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+type changeAction string
+
+const (
+	actionCreate changeAction = "create"
+	actionModify changeAction = "modify"
+	actionDelete changeAction = "delete"
+	actionNone   changeAction = "none"
+	actionSkip   changeAction = "skip"
+	// actionReview holds back a create as an existing hydrant is within
+	// the caution range
+	actionReview changeAction = "review"
+)
+
+// plannedChange describes what to do for a single surveyed hydrant
+type plannedChange struct {
+	Action changeAction
+	// Hydrant is the hydrant read from the survey
+	Hydrant *hydrant
+	// Found is the matched hydrant from OSM, nil if nothing matched. For
+	// changes needing review it is the nearest hydrant in caution range.
+	Found *hydrant
+	// Distance is the distance to the found hydrant in meters
+	Distance float64
+	// Target is the state of the hydrant after the change
+	Target *hydrant
+	// Node is the node to send to the API for create, modify and delete
+	Node *osm.Node
+	// Reason explains skipped changes
+	Reason    string
+	Decisions []policyDecision
+	// Changeset is the ID of the changeset the node was sent in, zero if
+	// it was not sent
+	Changeset int64
+	// Err is the error which occurred while sending the change
+	Err error
+}
+
+// nearestHydrant returns the nearest available hydrant within the
+// given range in meters and its distance
+func nearestHydrant(h *hydrant, availableHydrants []*hydrant, matchRange float64) (*hydrant, float64) {
+	var (
+		found    *hydrant
+		distance = math.MaxFloat64
+	)
+
+	for _, a := range availableHydrants {
+		dist := position.Haversine(h.Longitude, h.Latitude, a.Longitude, a.Latitude) * 1000
+		if dist <= matchRange && dist < distance {
+			found, distance = a, dist
+		}
+	}
+
+	return found, distance
+}
+
+// planChanges matches the surveyed hydrants against the hydrants
+// available in OSM and decides which changes to send
+func planChanges(hydrants, availableHydrants []*hydrant, policy *updatePolicy, currentUID int64) ([]*plannedChange, error) {
+	changes := []*plannedChange{}
+
+	for _, h := range hydrants {
+		c := &plannedChange{Hydrant: h}
+		changes = append(changes, c)
+
+		c.Found, c.Distance = nearestHydrant(h, availableHydrants, float64(cfg.MachRange))
+
+		if c.Found != nil {
+			if err := planMatch(c, policy, currentUID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if h.Removed {
+			c.Action = actionSkip
+			c.Reason = "marks a removed hydrant but no hydrant was found in OSM"
+			continue
+		}
+
+		if near, dist := nearestHydrant(h, availableHydrants, float64(cfg.CautionRange)); near != nil && !forcedCreate(h) {
+			// Probably a bad fix of an existing hydrant, creating would add a duplicate
+			c.Action, c.Found, c.Distance = actionReview, near, dist
+			c.Reason = fmt.Sprintf("hydrant %d is %.1fm away", near.ID, dist)
+			continue
+		}
+
+		planCreate(c)
+	}
+
+	return changes, nil
+}
+
+// planCreate plans creating a new node for the surveyed hydrant
+func planCreate(c *plannedChange) {
+	applySurveyDate(c.Hydrant, nil)
+	c.Action, c.Found, c.Distance, c.Target, c.Node = actionCreate, nil, 0, c.Hydrant, c.Hydrant.ToNode()
+	c.Reason, c.Decisions = "", nil
+}
+
+// planMatch plans the change of the found hydrant matched to the
+// surveyed hydrant
+func planMatch(c *plannedChange, policy *updatePolicy, currentUID int64) error {
+	h := c.Hydrant
+	c.Target, c.Node, c.Reason, c.Decisions = nil, nil, "", nil
+
+	if !surveyArea.Contains(c.Found.Latitude, c.Found.Longitude) {
+		c.Action, c.Reason = actionSkip, fmt.Sprintf("matched hydrant %d is outside of the area", c.Found.ID)
+		return nil
+	}
+
+	if reason := policy.skipReason(c.Found, currentUID); reason != "" {
+		c.Action, c.Reason = actionSkip, reason
+		return nil
+	}
+
+	if h.Removed {
+		planRemoval(c)
+		return nil
+	}
+
+	target, decisions, err := policy.merge(h, c.Found)
+	if err != nil {
+		return fmt.Errorf("Unable to merge waypoint %s into node %d: %s", h.Name, c.Found.ID, err)
+	}
+	c.Decisions = decisions
+
+	needsUpdate := c.Found.NeedsUpdate(target)
+	if needsUpdate {
+		applySurveyDate(target, c.Found)
+	} else if surveyDateExpired(c.Found) && applySurveyDate(target, c.Found) {
+		// Nothing changed but the hydrant was confirmed by the survey
+		log.Debugf("Updating survey date of unchanged hydrant %d", c.Found.ID)
+		needsUpdate = true
+	}
+
+	if !needsUpdate {
+		log.Debugf("Found a good looking hydrant which needs no update: %#v", h)
+		c.Found.Name = h.Name
+		c.Action, c.Target = actionNone, c.Found
+		return nil
+	}
+
+	c.Action, c.Target, c.Node = actionModify, target, target.ToNode()
+	return nil
+}
+
+// applyChanges sends the planned changes to the OSM API (or logs them in
+// noop mode) and returns the state of the hydrants after the changes.
+// Changes already recorded in the journal are skipped, failed changes
+// are returned as errors.
+func applyChanges(changes []*plannedChange, osmClient *osm.Client, j *journal) ([]*hydrant, []error) {
+	var (
+		result = []*hydrant{}
+		errs   = []error{}
+	)
+
+	for _, c := range changes {
+		switch c.Action {
+		case actionCreate, actionModify, actionDelete:
+			if e, ok := j.Completed(c.Hydrant); ok {
+				c.Action, c.Reason = actionNone, fmt.Sprintf("already sent (%s of node %d in changeset %d)", e.Action, e.NodeID, e.Changeset)
+				log.Infof("Skipping waypoint %s: %s", c.Hydrant.Name, c.Reason)
+				continue
+			}
+		}
+
+		var err error
+
+		switch c.Action {
+		case actionSkip:
+			log.Warnf("Skipped waypoint %s: %s", c.Hydrant.Name, c.Reason)
+
+		case actionReview:
+			log.Debugf("Held back create of waypoint %s for review: %s", c.Hydrant.Name, c.Reason)
+
+		case actionNone:
+			result = append(result, c.Target)
+
+		case actionCreate:
+			doNoOp(
+				fmt.Sprintf("[NOOP] Would send a create to OSM (Changeset %d): %#v", createChangeset(osmClient).ID, c.Node),
+				func() {
+					if err = sendChange(c, osmClient, j); err == nil {
+						log.Debugf("Created a hydrant: %s", c.Hydrant.Name)
+					}
+				},
+			)
+			if err == nil && c.Target != nil {
+				c.Target.ID, c.Target.Version = c.Node.ID, c.Node.Version
+				result = append(result, c.Target)
+			}
+
+		case actionModify:
+			doNoOp(
+				fmt.Sprintf("[NOOP] Would send a change to OSM (Changeset %d): To=%#v From=%#v", createChangeset(osmClient).ID, c.Node, c.Found.ToNode()),
+				func() {
+					if err = sendChange(c, osmClient, j); err == nil {
+						log.Debugf("Changed a hydrant: %s", c.Hydrant.Name)
+					}
+				},
+			)
+			if err == nil && c.Target != nil {
+				c.Target.Version = c.Node.Version
+				result = append(result, c.Target)
+			}
+
+		case actionDelete:
+			if cfg.NoOp {
+				log.Warnf("[NOOP] !!! Would DELETE hydrant node %d (waypoint %s) from OSM (Changeset %d): %#v",
+					c.Found.ID, c.Hydrant.Name, createChangeset(osmClient).ID, c.Node)
+				continue
+			}
+
+			if err = sendChange(c, osmClient, j); err == nil {
+				log.Warnf("Deleted hydrant node %d (waypoint %s)", c.Found.ID, c.Hydrant.Name)
+			}
+		}
+
+		if err != nil {
+			c.Err = err
+			log.Errorf("Unable to %s hydrant for waypoint %s: %s", c.Action, c.Hydrant.Name, err)
+			errs = append(errs, fmt.Errorf("waypoint %s (%s): %s", c.Hydrant.Name, c.Action, err))
+		}
+	}
+
+	logReviewList(changes)
+
+	return result, errs
+}
+
+// sendChange writes the node of the change to the API and records the
+// write in the journal
+func sendChange(c *plannedChange, osmClient *osm.Client, j *journal) error {
+	cs := createChangeset(osmClient)
+
+	var err error
+	if c.Action == actionDelete {
+		err = osmClient.DeleteNode(c.Node, cs)
+	} else {
+		err = osmClient.SaveNode(c.Node, cs)
+	}
+	if err != nil {
+		return err
+	}
+	c.Changeset = cs.ID
+
+	if err := j.Record(c, cs.ID); err != nil {
+		return fmt.Errorf("sent as node %d but unable to write journal: %s", c.Node.ID, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,427 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// planFormatVersion is increased on every incompatible change of the
+// plan file format
+const planFormatVersion = 1
+
+// planFile is the serialized version of the planned changes written by
+// the plan command and uploaded by the apply command
+type planFile struct {
+	FormatVersion int          `json:"format_version"`
+	Generator     string       `json:"generator"`
+	CreatedAt     time.Time    `json:"created_at"`
+	APIURL        string       `json:"api_url"`
+	Comment       string       `json:"comment"`
+	Source        string       `json:"source"`
+	Changes       []planChange `json:"changes"`
+}
+
+type planChange struct {
+	Action    changeAction      `json:"action"`
+	Waypoints []planWaypoint    `json:"waypoints"`
+	Distance  float64           `json:"distance,omitempty"`
+	NodeID    int64             `json:"node_id,omitempty"`
+	Latitude  float64           `json:"lat"`
+	Longitude float64           `json:"lon"`
+	Tags      map[string]string `json:"tags"`
+
+	// Base contains the state of the node the change was planned on, it
+	// is empty for creates
+	Base *planBase `json:"base,omitempty"`
+}
+
+type planBase struct {
+	Version   int64             `json:"version"`
+	Latitude  float64           `json:"lat"`
+	Longitude float64           `json:"lon"`
+	Tags      map[string]string `json:"tags"`
+}
+
+type planWaypoint struct {
+	Name      string    `json:"name"`
+	Latitude  float64   `json:"lat"`
+	Longitude float64   `json:"lon"`
+	Time      time.Time `json:"time"`
+	Comment   string    `json:"cmt,omitempty"`
+}
+
+// hydrant converts the base state into the hydrant found when planning
+func (b planBase) hydrant(id int64) *hydrant {
+	node := &osm.Node{ID: id, Version: b.Version, Latitude: b.Latitude, Longitude: b.Longitude}
+	for _, k := range sortedKeys(b.Tags) {
+		node.Tags = append(node.Tags, osm.Tag{Key: k, Value: b.Tags[k]})
+	}
+
+	h, err := fromNode(node)
+	if err != nil {
+		// Only used for logging, keep the position and version
+		return &hydrant{ID: id, Version: b.Version, Latitude: b.Latitude, Longitude: b.Longitude}
+	}
+
+	return h
+}
+
+func runPlan() {
+	if cfg.PlanFile == "" {
+		log.Fatalf("plan-file is a required parameter for plan")
+	}
+
+	changes, _, _ := planImport()
+
+	for _, c := range changes {
+		if c.Action == actionSkip {
+			log.Warnf("Skipped waypoint %s: %s", c.Hydrant.Name, c.Reason)
+		}
+	}
+	logReviewList(changes)
+
+	p := newPlanFile(changes)
+	if err := p.Write(cfg.PlanFile); err != nil {
+		log.Fatalf("Unable to write plan file: %s", err)
+	}
+
+	log.Infof("Wrote %d changes to %s", len(p.Changes), cfg.PlanFile)
+}
+
+func runApply() {
+	if cfg.PlanFile == "" {
+		log.Fatalf("plan-file is a required parameter for apply")
+	}
+
+	validateRemovalMode()
+
+	p, err := readPlanFile(cfg.PlanFile)
+	if err != nil {
+		log.Fatalf("Unable to read plan file: %s", err)
+	}
+
+	if p.APIURL != cfg.OSM.APIURL {
+		log.Fatalf("Plan was created for %s, refusing to apply it to %s", p.APIURL, cfg.OSM.APIURL)
+	}
+
+	if err := p.checkDeletes(); err != nil {
+		log.Fatalf("Refusing to apply plan: %s", err)
+	}
+
+	osmClient := newOSMClient()
+
+	j, err := openJournal(cfg.JournalFile)
+	if err != nil {
+		log.Fatalf("Unable to read journal: %s", err)
+	}
+
+	if err := p.checkBase(osmClient, cfg.Rebase, j); err != nil {
+		log.Fatalf("Refusing to apply plan: %s", err)
+	}
+
+	if p.Comment != "" {
+		cfg.Comment = p.Comment
+	}
+
+	applyAndReport(p.plannedChanges(), nil, osmClient)
+}
+
+// newPlanFile serializes all creates, modifications and deletions
+func newPlanFile(changes []*plannedChange) *planFile {
+	p := &planFile{
+		FormatVersion: planFormatVersion,
+		Generator:     fmt.Sprintf("gpxhydrant %s", version),
+		CreatedAt:     time.Now().UTC(),
+		APIURL:        cfg.OSM.APIURL,
+		Comment:       cfg.Comment,
+		Source:        cfg.GPXFile,
+		Changes:       []planChange{},
+	}
+
+	for _, c := range changes {
+		switch c.Action {
+		case actionCreate, actionModify, actionDelete:
+		default:
+			continue
+		}
+
+		pc := planChange{
+			Action:    c.Action,
+			Distance:  roundPrec(c.Distance, 1),
+			Latitude:  c.Node.Latitude,
+			Longitude: c.Node.Longitude,
+			Tags:      nodeTagMap(c.Node),
+		}
+
+		for _, wp := range c.Hydrant.Sources {
+			pc.Waypoints = append(pc.Waypoints, planWaypoint{
+				Name:      wp.Name,
+				Latitude:  wp.Latitude,
+				Longitude: wp.Longitude,
+				Time:      wp.Time,
+				Comment:   wp.Comment,
+			})
+		}
+
+		if c.Found != nil {
+			pc.NodeID = c.Found.ID
+			pc.Base = &planBase{
+				Version:   c.Found.Version,
+				Latitude:  c.Found.Latitude,
+				Longitude: c.Found.Longitude,
+				Tags:      tagMap(c.Found),
+			}
+		}
+
+		p.Changes = append(p.Changes, pc)
+	}
+
+	return p
+}
+
+func readPlanFile(filename string) (*planFile, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	p := &planFile{}
+	if err := json.NewDecoder(f).Decode(p); err != nil {
+		return nil, err
+	}
+
+	if p.FormatVersion != planFormatVersion {
+		return nil, fmt.Errorf("Unsupported plan format version %d (expected %d)", p.FormatVersion, planFormatVersion)
+	}
+
+	return p, nil
+}
+
+// Write stores the plan as JSON
+func (p *planFile) Write(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(p); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// checkDeletes refuses plans containing deletions unless deleting nodes
+// was allowed for this run
+func (p *planFile) checkDeletes() error {
+	if cfg.AllowDelete {
+		return nil
+	}
+
+	for _, c := range p.Changes {
+		if c.Action == actionDelete {
+			return fmt.Errorf("plan deletes node %d, --allow-delete is required to apply it", c.NodeID)
+		}
+	}
+
+	return nil
+}
+
+// checkBase compares the base versions of all modified and deleted nodes
+// to their live versions. If rebase is set changes to nodes edited in the
+// meantime are applied to the live version if they don't conflict.
+// Changes already recorded in the journal are not checked as their nodes
+// were changed by an earlier run of the plan.
+func (p *planFile) checkBase(osmClient *osm.Client, rebase bool, j *journal) error {
+	pending := map[int]bool{}
+	ids := []int64{}
+	for i, c := range p.Changes {
+		if c.Base == nil {
+			continue
+		}
+		if _, ok := j.Completed(c.sourceHydrant()); ok {
+			continue
+		}
+		pending[i] = true
+		ids = append(ids, c.NodeID)
+	}
+
+	nodes, err := osmClient.GetNodes(ids)
+	if err != nil {
+		return fmt.Errorf("Unable to retrieve nodes: %s", err)
+	}
+
+	live := map[int64]*osm.Node{}
+	for _, n := range nodes {
+		live[n.ID] = n
+	}
+
+	problems := []string{}
+	for i := range p.Changes {
+		c := &p.Changes[i]
+		if !pending[i] {
+			continue
+		}
+
+		n, ok := live[c.NodeID]
+		switch {
+		case (!ok || !n.Visible) && c.Action == actionDelete && j.Deleted(c.NodeID):
+			// Deleted by an earlier run without recording all waypoints
+		case !ok || !n.Visible:
+			problems = append(problems, fmt.Sprintf("node %d has been deleted", c.NodeID))
+		case n.Version == c.Base.Version:
+			// Unchanged since planning
+		case !rebase:
+			problems = append(problems, fmt.Sprintf("node %d was changed (planned on version %d, now version %d)", c.NodeID, c.Base.Version, n.Version))
+		default:
+			if err := c.rebase(n); err != nil {
+				problems = append(problems, fmt.Sprintf("node %d can not be rebased: %s", c.NodeID, err))
+				continue
+			}
+			log.Infof("Rebased change of node %d from version %d onto version %d", c.NodeID, c.Base.Version, n.Version)
+			c.Base.Version = n.Version
+		}
+	}
+
+	if len(problems) > 0 {
+		if !rebase {
+			problems = append(problems, "use --rebase to apply the changes to the current versions")
+		}
+		return fmt.Errorf("%s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// rebase applies the change to the live version of the node. Fails if
+// the node was changed in the same tags or position as the planned change
+// or if a deleted node was changed at all.
+func (c *planChange) rebase(n *osm.Node) error {
+	liveTags := nodeTagMap(n)
+
+	if c.Action == actionDelete {
+		if n.Latitude != c.Base.Latitude || n.Longitude != c.Base.Longitude || len(tagDiffKeys(c.Base.Tags, liveTags)) > 0 {
+			return fmt.Errorf("node to delete was modified")
+		}
+		return nil
+	}
+
+	tags := map[string]string{}
+	for k, v := range liveTags {
+		tags[k] = v
+	}
+
+	for _, k := range tagDiffKeys(c.Base.Tags, c.Tags) {
+		planned, inPlan := c.Tags[k]
+		if liveTags[k] != c.Base.Tags[k] && liveTags[k] != planned {
+			return fmt.Errorf("tag %s was changed to %q", k, liveTags[k])
+		}
+
+		if inPlan {
+			tags[k] = planned
+		} else {
+			delete(tags, k)
+		}
+	}
+
+	lat, lon := c.Latitude, c.Longitude
+	if lat == c.Base.Latitude && lon == c.Base.Longitude {
+		// No move planned, keep the live position
+		lat, lon = n.Latitude, n.Longitude
+	} else if n.Latitude != c.Base.Latitude || n.Longitude != c.Base.Longitude {
+		return fmt.Errorf("node was moved")
+	}
+
+	c.Latitude, c.Longitude, c.Tags = lat, lon, tags
+	c.Base.Latitude, c.Base.Longitude, c.Base.Tags = n.Latitude, n.Longitude, liveTags
+
+	return nil
+}
+
+// tagDiffKeys returns the keys having different values in a and b
+func tagDiffKeys(a, b map[string]string) []string {
+	keys := map[string]string{}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			keys[k] = ""
+		}
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys[k] = ""
+		}
+	}
+	return sortedKeys(keys)
+}
+
+// plannedChanges converts the plan back into changes to apply
+func (p *planFile) plannedChanges() []*plannedChange {
+	out := []*plannedChange{}
+
+	for _, c := range p.Changes {
+		node := &osm.Node{
+			ID:        c.NodeID,
+			Latitude:  c.Latitude,
+			Longitude: c.Longitude,
+		}
+		for _, k := range sortedKeys(c.Tags) {
+			node.Tags = append(node.Tags, osm.Tag{Key: k, Value: c.Tags[k]})
+		}
+
+		pc := &plannedChange{
+			Action:   c.Action,
+			Hydrant:  c.sourceHydrant(),
+			Distance: c.Distance,
+			Node:     node,
+		}
+
+		if c.Base != nil {
+			node.Version = c.Base.Version
+			pc.Found = c.Base.hydrant(c.NodeID)
+		}
+
+		if c.Action != actionDelete {
+			// Lifecycle retagged nodes are no hydrants anymore and have no target
+			if t, err := fromNode(node); err == nil {
+				t.Name = pc.Hydrant.Name
+				pc.Target = t
+			}
+		}
+
+		out = append(out, pc)
+	}
+
+	return out
+}
+
+// sourceHydrant returns a hydrant at the planned position with the
+// waypoints the change was planned from as sources
+func (c planChange) sourceHydrant() *hydrant {
+	h := &hydrant{Latitude: c.Latitude, Longitude: c.Longitude}
+
+	names := []string{}
+	for _, wp := range c.Waypoints {
+		names = append(names, wp.Name)
+		h.Sources = append(h.Sources, gpx.Waypoint{
+			Name:      wp.Name,
+			Latitude:  wp.Latitude,
+			Longitude: wp.Longitude,
+			Time:      wp.Time,
+			Comment:   wp.Comment,
+		})
+	}
+	h.Name = strings.Join(names, "+")
+
+	return h
+}
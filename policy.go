@@ -0,0 +1,231 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	overwriteAlways  = "always"
+	overwriteNever   = "never"
+	overwriteIfEmpty = "if_empty"
+)
+
+// updatePolicy controls which tags of existing OSM hydrants a survey is
+// allowed to overwrite
+type updatePolicy struct {
+	// Fields contains the rules per tag key
+	Fields map[string]fieldPolicy `yaml:"fields"`
+	// SkipRecentlyEditedDays skips all changes to nodes edited by other
+	// users within the given number of days
+	SkipRecentlyEditedDays int64 `yaml:"skip_recently_edited_days"`
+}
+
+type fieldPolicy struct {
+	// Overwrite is one of "always" (default), "never" or "if_empty"
+	Overwrite string `yaml:"overwrite"`
+	// ProtectIfTags prevents overwriting the value if the OSM node has
+	// all of the given tags ("*" matches any value)
+	ProtectIfTags map[string]string `yaml:"protect_if_tags"`
+	// NeverDecrease prevents overwriting numeric values with lower ones
+	NeverDecrease bool `yaml:"never_decrease"`
+}
+
+// policyDecision records the decision taken for a tag differing between
+// the survey and OSM
+type policyDecision struct {
+	NodeID   int64
+	Waypoint string
+	Key      string
+	Old      string
+	New      string
+	Applied  bool
+	Rule     string
+}
+
+func (p policyDecision) String() string {
+	verb := "overwrote"
+	if !p.Applied {
+		verb = "kept"
+	}
+	return fmt.Sprintf("Node %d (waypoint %s): %s %s %q (survey: %q, rule: %s)", p.NodeID, p.Waypoint, verb, p.Key, p.Old, p.New, p.Rule)
+}
+
+func loadUpdatePolicy(filename string) (*updatePolicy, error) {
+	p := &updatePolicy{}
+	if filename == "" {
+		return p, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, p); err != nil {
+		return nil, err
+	}
+
+	for key, f := range p.Fields {
+		switch f.Overwrite {
+		case "", overwriteAlways, overwriteNever, overwriteIfEmpty:
+		default:
+			return nil, fmt.Errorf("Invalid overwrite value %q for field %s", f.Overwrite, key)
+		}
+	}
+
+	return p, nil
+}
+
+// skipReason returns a reason why the found hydrant must not be changed
+// at all or an empty string if changes are allowed
+func (p updatePolicy) skipReason(found *hydrant, currentUID int64) string {
+	if p.SkipRecentlyEditedDays <= 0 || found.LastEdit.IsZero() || found.LastEditUID == currentUID {
+		return ""
+	}
+
+	if found.LastEdit.After(time.Now().AddDate(0, 0, -int(p.SkipRecentlyEditedDays))) {
+		return fmt.Sprintf("edited by %s on %s, less than %d days ago",
+			found.LastEditUser, found.LastEdit.Format("2006-01-02"), p.SkipRecentlyEditedDays)
+	}
+
+	return ""
+}
+
+// allowOverwrite decides whether the value of the key in the found
+// hydrant may be replaced by the surveyed value and returns the rule
+// leading to the decision
+func (p updatePolicy) allowOverwrite(key, oldValue, newValue string, found map[string]string) (bool, string) {
+	if key == "fire_hydrant:type" && oldValue != "" && !cfg.AllowTypeChange {
+		return false, "type changes require --allow-type-change"
+	}
+
+	f, ok := p.Fields[key]
+	if !ok {
+		return true, "default"
+	}
+
+	switch f.Overwrite {
+	case overwriteNever:
+		if oldValue != "" {
+			return false, "overwrite: never"
+		}
+	case overwriteIfEmpty:
+		if oldValue != "" {
+			return false, "overwrite: if_empty"
+		}
+	}
+
+	if len(f.ProtectIfTags) > 0 {
+		protected := true
+		for k, v := range f.ProtectIfTags {
+			if fv, ok := found[k]; !ok || (v != "*" && fv != v) {
+				protected = false
+			}
+		}
+		if protected && oldValue != "" {
+			return false, "protect_if_tags"
+		}
+	}
+
+	if f.NeverDecrease && oldValue != "" {
+		ov, oerr := strconv.ParseFloat(oldValue, 64)
+		nv, nerr := strconv.ParseFloat(newValue, 64)
+		if oerr == nil && nerr == nil && nv < ov {
+			return false, "never_decrease"
+		}
+	}
+
+	return true, fmt.Sprintf("field %s", key)
+}
+
+// merge creates the target state of the found hydrant by applying all
+// surveyed tags allowed by the policy to the tags of the found hydrant
+func (p updatePolicy) merge(h, found *hydrant) (*hydrant, []policyDecision, error) {
+	var (
+		current   = tagMap(found)
+		survey    = tagMap(h)
+		target    = map[string]string{}
+		decisions = []policyDecision{}
+	)
+
+	for k, v := range current {
+		target[k] = v
+	}
+
+	for _, k := range sortedKeys(survey) {
+		if survey[k] == current[k] {
+			continue
+		}
+
+		if h.ZoneDefaults[k] && current[k] != "" {
+			log.Debugf("Node %d (waypoint %s): kept %s %q instead of zone default %q", found.ID, h.Name, k, current[k], survey[k])
+			continue
+		}
+
+		allowed, rule := p.allowOverwrite(k, current[k], survey[k], current)
+		decisions = append(decisions, policyDecision{
+			NodeID:   found.ID,
+			Waypoint: h.Name,
+			Key:      k,
+			Old:      current[k],
+			New:      survey[k],
+			Applied:  allowed,
+			Rule:     rule,
+		})
+
+		if allowed {
+			target[k] = survey[k]
+		}
+	}
+
+	out := &hydrant{
+		ID:        found.ID,
+		Version:   found.Version,
+		Name:      h.Name,
+		Latitude:  h.Latitude,
+		Longitude: h.Longitude,
+
+		HDOP:       h.HDOP,
+		Sources:    h.Sources,
+		CodeSource: h.CodeSource,
+		SurveyDate: h.SurveyDate,
+	}
+
+	for _, k := range sortedKeys(target) {
+		if err := out.setTag(k, target[k]); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return out, decisions, nil
+}
+
+func logPolicyDecisions(decisions []policyDecision) {
+	for _, d := range decisions {
+		if d.Applied {
+			log.Info(d.String())
+			continue
+		}
+		log.Warn(d.String())
+	}
+}
+
+// tagMap returns the tags the hydrant would have as a node
+func tagMap(h *hydrant) map[string]string {
+	return nodeTagMap(h.ToNode())
+}
+
+func nodeTagMap(n *osm.Node) map[string]string {
+	out := map[string]string{}
+	for _, t := range n.Tags {
+		out[t.Key] = t.Value
+	}
+	return out
+}
@@ -0,0 +1,91 @@
+// Package poly parses polygon filter files in the Osmosis .poly format
+// as used for OSM extracts.
+package poly
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Poly contains the name of the polygon file and all its sections
+type Poly struct {
+	Name     string
+	Sections []*Section
+}
+
+// Section is a single ring of the polygon. Rings with a name starting
+// with an exclamation mark are holes cut out of the area.
+type Section struct {
+	Name string
+	Hole bool
+	// Points contains the coordinates as longitude, latitude pairs
+	Points [][2]float64
+}
+
+// ParsePolyData reads a .poly file
+func ParsePolyData(in io.Reader) (*Poly, error) {
+	var (
+		out     = &Poly{}
+		section *Section
+		done    bool
+	)
+
+	scanner := bufio.NewScanner(in)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case done:
+			if text != "" {
+				return nil, fmt.Errorf("Line %d: Content after the end of the file", line)
+			}
+
+		case line == 1:
+			out.Name = text
+
+		case text == "" && section == nil:
+			// Ignore empty lines between sections
+
+		case text == "END" && section == nil:
+			done = true
+
+		case text == "END":
+			out.Sections = append(out.Sections, section)
+			section = nil
+
+		case section == nil:
+			section = &Section{Name: strings.TrimPrefix(text, "!"), Hole: strings.HasPrefix(text, "!")}
+
+		default:
+			fields := strings.Fields(text)
+			if len(fields) != 2 {
+				return nil, fmt.Errorf("Line %d: Expected longitude and latitude, got %q", line, text)
+			}
+
+			lon, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: Invalid longitude: %s", line, err)
+			}
+			lat, err := strconv.ParseFloat(fields[1], 64)
+			if err != nil {
+				return nil, fmt.Errorf("Line %d: Invalid latitude: %s", line, err)
+			}
+
+			section.Points = append(section.Points, [2]float64{lon, lat})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if !done {
+		return nil, errors.New("Unexpected end of file, missing END")
+	}
+
+	return out, nil
+}
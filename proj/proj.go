@@ -0,0 +1,230 @@
+// Package proj converts projected coordinates used in German datasets
+// (UTM on ETRS89 / WGS84, Gauss-Krüger on DHDN) into WGS84 latitude and
+// longitude without using any external service.
+package proj
+
+import (
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Ellipsoid describes a reference ellipsoid by its semi-major axis and flattening
+type Ellipsoid struct {
+	A float64
+	F float64
+}
+
+// Reference ellipsoids used by the supported coordinate reference systems
+var (
+	WGS84      = Ellipsoid{A: 6378137, F: 1 / 298.257223563}
+	GRS80      = Ellipsoid{A: 6378137, F: 1 / 298.257222101}
+	Bessel1841 = Ellipsoid{A: 6377397.155, F: 1 / 299.1528128}
+)
+
+func (e Ellipsoid) e2() float64 { return e.F * (2 - e.F) }
+
+// Helmert contains the parameters of a 7-parameter datum transformation
+// (position vector convention) into WGS84. Translations are given in
+// meters, rotations in arc-seconds and the scale in ppm.
+type Helmert struct {
+	TX, TY, TZ float64
+	RX, RY, RZ float64
+	S          float64
+}
+
+// DHDNToWGS84 is the transformation for the DHDN datum used with
+// Gauss-Krüger coordinates in Germany (accuracy ~3m)
+var DHDNToWGS84 = &Helmert{TX: 598.1, TY: 73.7, TZ: 418.2, RX: 0.202, RY: 0.045, RZ: -2.455, S: 6.7}
+
+// TransverseMercator describes a transverse Mercator projection
+type TransverseMercator struct {
+	Ellipsoid       Ellipsoid
+	CentralMeridian float64 // degrees
+	ScaleFactor     float64
+	FalseEasting    float64
+	FalseNorthing   float64
+
+	// Datum transforms the result of the inverse projection into WGS84,
+	// nil if the ellipsoid is already (nearly) identical to WGS84
+	Datum *Helmert
+
+	// zoneFromEasting enables deriving the zone from the zone prefix
+	// of the easting
+	zoneFromEasting bool
+}
+
+// UTM returns the projection for the northern UTM zone on ETRS89
+func UTM(zone int) *TransverseMercator {
+	return &TransverseMercator{
+		Ellipsoid:       GRS80,
+		CentralMeridian: float64(zone)*6 - 183,
+		ScaleFactor:     0.9996,
+		FalseEasting:    float64(zone)*1000000 + 500000,
+	}
+}
+
+// GaussKrueger returns the projection for the Gauss-Krüger zone on DHDN
+func GaussKrueger(zone int) *TransverseMercator {
+	return &TransverseMercator{
+		Ellipsoid:       Bessel1841,
+		CentralMeridian: float64(zone) * 3,
+		ScaleFactor:     1,
+		FalseEasting:    float64(zone)*1000000 + 500000,
+		Datum:           DHDNToWGS84,
+	}
+}
+
+var crsPattern = regexp.MustCompile(`^(?:(wgs84|epsg:4326)|(utm|epsg:258|epsg:326)([0-9]{2})|gk([2-5])?|epsg:3146([6-9]))$`)
+
+// Parse resolves a coordinate reference system name. Supported are
+// "wgs84" / "EPSG:4326" (returns nil), "utm<zone>" / "EPSG:258<zone>"
+// (ETRS89), "EPSG:326<zone>" (WGS84) and "gk<zone>" / "EPSG:3146<zone+4>".
+// A "gk" without zone derives the zone from the first digit of the easting.
+func Parse(name string) (*TransverseMercator, error) {
+	m := crsPattern.FindStringSubmatch(strings.ToLower(strings.TrimSpace(name)))
+	switch {
+	case m == nil:
+		return nil, fmt.Errorf("Unsupported coordinate reference system %q", name)
+
+	case m[1] != "":
+		return nil, nil
+
+	case m[3] != "":
+		zone, _ := strconv.Atoi(m[3])
+		if zone < 1 || zone > 60 {
+			return nil, fmt.Errorf("Invalid UTM zone %d", zone)
+		}
+		t := UTM(zone)
+		if m[2] == "epsg:326" {
+			t.Ellipsoid = WGS84
+		}
+		return t, nil
+
+	case m[4] != "":
+		zone, _ := strconv.Atoi(m[4])
+		return GaussKrueger(zone), nil
+
+	case m[5] != "":
+		zone, _ := strconv.Atoi(m[5])
+		return GaussKrueger(zone - 4), nil
+
+	default:
+		t := GaussKrueger(0)
+		t.zoneFromEasting = true
+		return t, nil
+	}
+}
+
+// ToWGS84 converts easting and northing into WGS84 latitude and
+// longitude. Eastings without the zone prefix are accepted too.
+func (t TransverseMercator) ToWGS84(easting, northing float64) (lat, lon float64, err error) {
+	if t.zoneFromEasting {
+		zone := math.Floor(easting / 1000000)
+		if zone < 1 {
+			return 0, 0, fmt.Errorf("Easting %.3f does not contain a zone prefix", easting)
+		}
+		t.CentralMeridian = zone * 3
+		t.FalseEasting = zone*1000000 + 500000
+	}
+
+	if easting < 1000000 {
+		// Easting without zone prefix
+		t.FalseEasting = math.Mod(t.FalseEasting, 1000000)
+	}
+
+	lat, lon = t.inverse(easting, northing)
+
+	if t.Datum != nil {
+		lat, lon = t.Datum.transform(t.Ellipsoid, lat, lon)
+	}
+
+	return lat, lon, nil
+}
+
+// inverse calculates the geographic coordinates on the ellipsoid of the
+// projection (Snyder, Map Projections - A Working Manual, p. 63)
+func (t TransverseMercator) inverse(easting, northing float64) (lat, lon float64) {
+	var (
+		a   = t.Ellipsoid.A
+		e2  = t.Ellipsoid.e2()
+		ep2 = e2 / (1 - e2)
+		k0  = t.ScaleFactor
+
+		x = easting - t.FalseEasting
+		y = northing - t.FalseNorthing
+	)
+
+	mu := y / k0 / (a * (1 - e2/4 - 3*e2*e2/64 - 5*e2*e2*e2/256))
+	e1 := (1 - math.Sqrt(1-e2)) / (1 + math.Sqrt(1-e2))
+
+	phi1 := mu +
+		(3*e1/2-27*math.Pow(e1, 3)/32)*math.Sin(2*mu) +
+		(21*e1*e1/16-55*math.Pow(e1, 4)/32)*math.Sin(4*mu) +
+		(151*math.Pow(e1, 3)/96)*math.Sin(6*mu) +
+		(1097*math.Pow(e1, 4)/512)*math.Sin(8*mu)
+
+	sinPhi1, cosPhi1, tanPhi1 := math.Sin(phi1), math.Cos(phi1), math.Tan(phi1)
+
+	c1 := ep2 * cosPhi1 * cosPhi1
+	t1 := tanPhi1 * tanPhi1
+	n1 := a / math.Sqrt(1-e2*sinPhi1*sinPhi1)
+	r1 := a * (1 - e2) / math.Pow(1-e2*sinPhi1*sinPhi1, 1.5)
+	d := x / (n1 * k0)
+
+	lat = phi1 - (n1*tanPhi1/r1)*(d*d/2-
+		(5+3*t1+10*c1-4*c1*c1-9*ep2)*math.Pow(d, 4)/24+
+		(61+90*t1+298*c1+45*t1*t1-252*ep2-3*c1*c1)*math.Pow(d, 6)/720)
+
+	lon = (d - (1+2*t1+c1)*math.Pow(d, 3)/6 +
+		(5-2*c1+28*t1-3*c1*c1+8*ep2+24*t1*t1)*math.Pow(d, 5)/120) / cosPhi1
+
+	return lat * 180 / math.Pi, t.CentralMeridian + lon*180/math.Pi
+}
+
+// transform converts geographic coordinates on the given ellipsoid into
+// WGS84 using the Helmert transformation
+func (h Helmert) transform(from Ellipsoid, lat, lon float64) (float64, float64) {
+	const arcsec = math.Pi / 180 / 3600
+
+	x, y, z := toCartesian(from, lat, lon)
+
+	var (
+		s  = 1 + h.S/1e6
+		rx = h.RX * arcsec
+		ry = h.RY * arcsec
+		rz = h.RZ * arcsec
+	)
+
+	x2 := h.TX + s*(x-rz*y+ry*z)
+	y2 := h.TY + s*(rz*x+y-rx*z)
+	z2 := h.TZ + s*(-ry*x+rx*y+z)
+
+	return fromCartesian(WGS84, x2, y2, z2)
+}
+
+func toCartesian(e Ellipsoid, lat, lon float64) (x, y, z float64) {
+	phi, lambda := lat*math.Pi/180, lon*math.Pi/180
+	e2 := e.e2()
+
+	n := e.A / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+
+	return n * math.Cos(phi) * math.Cos(lambda),
+		n * math.Cos(phi) * math.Sin(lambda),
+		n * (1 - e2) * math.Sin(phi)
+}
+
+func fromCartesian(e Ellipsoid, x, y, z float64) (lat, lon float64) {
+	e2 := e.e2()
+	p := math.Sqrt(x*x + y*y)
+
+	phi := math.Atan2(z, p*(1-e2))
+	for i := 0; i < 10; i++ {
+		n := e.A / math.Sqrt(1-e2*math.Sin(phi)*math.Sin(phi))
+		phi = math.Atan2(z+e2*n*math.Sin(phi), p)
+	}
+
+	return phi * 180 / math.Pi, math.Atan2(y, x) * 180 / math.Pi
+}
@@ -0,0 +1,109 @@
+package proj
+
+import (
+	"math"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, c := range []struct {
+		Name            string
+		WGS84           bool
+		Err             bool
+		Ellipsoid       Ellipsoid
+		CentralMeridian float64
+		ZoneFromEasting bool
+	}{
+		{Name: "wgs84", WGS84: true},
+		{Name: "EPSG:4326", WGS84: true},
+		{Name: "utm32", Ellipsoid: GRS80, CentralMeridian: 9},
+		{Name: " UTM33 ", Ellipsoid: GRS80, CentralMeridian: 15},
+		{Name: "EPSG:25832", Ellipsoid: GRS80, CentralMeridian: 9},
+		{Name: "EPSG:32632", Ellipsoid: WGS84, CentralMeridian: 9},
+		{Name: "gk3", Ellipsoid: Bessel1841, CentralMeridian: 9},
+		{Name: "EPSG:31468", Ellipsoid: Bessel1841, CentralMeridian: 12},
+		{Name: "gk", Ellipsoid: Bessel1841, ZoneFromEasting: true},
+		{Name: "utm61", Err: true},
+		{Name: "gk6", Err: true},
+		{Name: "EPSG:3857", Err: true},
+	} {
+		tm, err := Parse(c.Name)
+		switch {
+		case c.Err:
+			if err == nil {
+				t.Errorf("%q: expected error", c.Name)
+			}
+		case err != nil:
+			t.Errorf("%q: unexpected error: %s", c.Name, err)
+		case c.WGS84:
+			if tm != nil {
+				t.Errorf("%q: expected no projection, got %#v", c.Name, tm)
+			}
+		case tm == nil:
+			t.Errorf("%q: expected projection, got nil", c.Name)
+		case tm.Ellipsoid != c.Ellipsoid || tm.CentralMeridian != c.CentralMeridian || tm.zoneFromEasting != c.ZoneFromEasting:
+			t.Errorf("%q: unexpected projection %#v", c.Name, tm)
+		}
+	}
+}
+
+func TestToWGS84(t *testing.T) {
+	// Tolerance of about 1m, the datum transformation of DHDN is only
+	// accurate to a few meters anyway
+	const tolerance = 0.00001
+
+	for _, c := range []struct {
+		Name              string
+		Projection        *TransverseMercator
+		Easting, Northing float64
+		Lat, Lon          float64
+	}{
+		{"utm32 equator", UTM(32), 32500000, 0, 0, 9},
+		{"utm32 without zone", UTM(32), 500000, 0, 0, 9},
+		{"utm32 Wedel", UTM(32), 32546323.086, 5939246.729, 53.6, 9.7},
+		{"utm32 Munich", UTM(32), 691567.326, 5334734.331, 48.137, 11.575},
+		{"utm33 Berlin", UTM(33), 33389917.833, 5819701.919, 52.5163, 13.3777},
+		{"gk3 Wedel", GaussKrueger(3), 3546412.628, 5941183.996, 53.6, 9.7},
+		{"gk4 Munich", GaussKrueger(4), 4468476.027, 5333305.997, 48.137, 11.575},
+		{"gk4 Berlin without zone", GaussKrueger(4), 593626.580, 5821244.942, 52.5163, 13.3777},
+	} {
+		lat, lon, err := c.Projection.ToWGS84(c.Easting, c.Northing)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", c.Name, err)
+			continue
+		}
+
+		if math.Abs(lat-c.Lat) > tolerance || math.Abs(lon-c.Lon) > tolerance {
+			t.Errorf("%s: expected %.6f,%.6f, got %.6f,%.6f", c.Name, c.Lat, c.Lon, lat, lon)
+		}
+	}
+}
+
+func TestToWGS84ZoneFromEasting(t *testing.T) {
+	tm, err := Parse("gk")
+	if err != nil {
+		t.Fatalf("Unable to parse gk: %s", err)
+	}
+
+	for _, c := range []struct {
+		Easting, Northing float64
+		Lat, Lon          float64
+	}{
+		{3546412.628, 5941183.996, 53.6, 9.7},
+		{4593626.580, 5821244.942, 52.5163, 13.3777},
+	} {
+		lat, lon, err := tm.ToWGS84(c.Easting, c.Northing)
+		if err != nil {
+			t.Errorf("%.3f: unexpected error: %s", c.Easting, err)
+			continue
+		}
+
+		if math.Abs(lat-c.Lat) > 0.00001 || math.Abs(lon-c.Lon) > 0.00001 {
+			t.Errorf("%.3f: expected %.6f,%.6f, got %.6f,%.6f", c.Easting, c.Lat, c.Lon, lat, lon)
+		}
+	}
+
+	if _, _, err := tm.ToWGS84(546412.628, 5941183.996); err == nil {
+		t.Error("Expected error for easting without zone prefix")
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"regexp"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+const removalModeDelete = "delete"
+
+var (
+	removalLifecyclePrefixes = map[string]string{
+		"disused": "disused:",
+		"removed": "removed:",
+	}
+
+	// hydrantSpecificKeys are the tags only describing the hydrant, they
+	// get the lifecycle prefix when the hydrant is removed
+	hydrantSpecificKeys = regexp.MustCompile(`^(emergency|water_source|couplings|colour)$|^(fire_hydrant|couplings):`)
+)
+
+func validateRemovalMode() {
+	if cfg.RemovalMode == removalModeDelete {
+		if !cfg.AllowDelete {
+			log.Fatalf("removal-mode=delete requires --allow-delete to be set")
+		}
+		return
+	}
+
+	if _, ok := removalLifecyclePrefixes[cfg.RemovalMode]; !ok {
+		log.Fatalf("Unknown removal-mode %q (valid: disused, removed, delete)", cfg.RemovalMode)
+	}
+}
+
+// planRemoval plans deleting the node of the found hydrant or retagging
+// it using the lifecycle prefix configured by the removal-mode
+func planRemoval(c *plannedChange) {
+	if cfg.RemovalMode == removalModeDelete {
+		c.Action, c.Node = actionDelete, c.Found.ToNode()
+		return
+	}
+
+	c.Action, c.Node = actionModify, lifecycleNode(c.Found, removalLifecyclePrefixes[cfg.RemovalMode])
+}
+
+// lifecycleNode converts the hydrant into a node having all hydrant
+// specific tags replaced by their lifecycle prefixed version
+func lifecycleNode(h *hydrant, prefix string) *osm.Node {
+	node := h.ToNode()
+
+	for i, t := range node.Tags {
+		if hydrantSpecificKeys.MatchString(t.Key) {
+			node.Tags[i].Key = prefix + t.Key
+		}
+	}
+
+	return node
+}
@@ -0,0 +1,284 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Outcomes of a waypoint in the run report
+const (
+	outcomeCreated   = "created"
+	outcomeUpdated   = "updated"
+	outcomeDeleted   = "deleted"
+	outcomeUnchanged = "unchanged"
+	outcomeSkipped   = "skipped"
+	outcomeConflict  = "conflict"
+	outcomeFailed    = "failed"
+)
+
+type runReport struct {
+	Generator string         `json:"generator"`
+	CreatedAt time.Time      `json:"created_at"`
+	Source    string         `json:"source"`
+	NoOp      bool           `json:"noop"`
+	Summary   map[string]int `json:"summary"`
+	Entries   []reportEntry  `json:"entries"`
+}
+
+type reportEntry struct {
+	Waypoint  string      `json:"waypoint"`
+	Latitude  float64     `json:"lat"`
+	Longitude float64     `json:"lon"`
+	Comment   string      `json:"cmt"`
+	Outcome   string      `json:"outcome"`
+	Reason    string      `json:"reason,omitempty"`
+	NodeID    int64       `json:"node_id,omitempty"`
+	NodeURL   string      `json:"node_url,omitempty"`
+	Changeset int64       `json:"changeset,omitempty"`
+	Distance  float64     `json:"distance,omitempty"`
+	Tags      []reportTag `json:"tags,omitempty"`
+}
+
+type reportTag struct {
+	Key    string `json:"key"`
+	Before string `json:"before"`
+	After  string `json:"after"`
+}
+
+// newRunReport creates a report entry for every source waypoint of the
+// changes and for every skipped waypoint
+func newRunReport(changes []*plannedChange, skipped []skippedWaypoint) *runReport {
+	r := &runReport{
+		Generator: fmt.Sprintf("gpxhydrant %s", version),
+		CreatedAt: time.Now().UTC(),
+		Source:    cfg.GPXFile,
+		NoOp:      cfg.NoOp,
+		Summary:   map[string]int{},
+		Entries:   []reportEntry{},
+	}
+
+	for _, s := range skipped {
+		r.add(reportEntry{
+			Waypoint:  s.Waypoint.Name,
+			Latitude:  s.Waypoint.Latitude,
+			Longitude: s.Waypoint.Longitude,
+			Comment:   s.Waypoint.Comment,
+			Outcome:   outcomeSkipped,
+			Reason:    s.Reason,
+		})
+	}
+
+	for _, c := range changes {
+		e := reportEntry{
+			Outcome:   changeOutcome(c),
+			Reason:    c.Reason,
+			Changeset: c.Changeset,
+			Distance:  roundPrec(c.Distance, 1),
+		}
+
+		if c.Err != nil {
+			e.Reason = c.Err.Error()
+		}
+
+		switch {
+		case c.Node != nil && c.Node.ID > 0:
+			e.NodeID = c.Node.ID
+		case c.Found != nil:
+			e.NodeID = c.Found.ID
+		}
+		if e.NodeID > 0 {
+			e.NodeURL = fmt.Sprintf("%s/node/%d", osmWebURL(), e.NodeID)
+		}
+
+		if c.Node != nil && c.Action != actionReview {
+			var before map[string]string
+			if c.Found != nil {
+				before = tagMap(c.Found)
+			}
+			after := nodeTagMap(c.Node)
+			if c.Action == actionDelete {
+				after = map[string]string{}
+			}
+
+			for _, k := range tagDiffKeys(before, after) {
+				e.Tags = append(e.Tags, reportTag{Key: k, Before: before[k], After: after[k]})
+			}
+		}
+
+		for _, wp := range c.Hydrant.Sources {
+			e.Waypoint, e.Latitude, e.Longitude, e.Comment = wp.Name, wp.Latitude, wp.Longitude, wp.Comment
+			r.add(e)
+		}
+	}
+
+	return r
+}
+
+func (r *runReport) add(e reportEntry) {
+	r.Entries = append(r.Entries, e)
+	r.Summary[e.Outcome]++
+}
+
+// Warnings returns the number of waypoints which were skipped or need
+// to be reviewed
+func (r *runReport) Warnings() int {
+	return r.Summary[outcomeSkipped] + r.Summary[outcomeConflict]
+}
+
+func changeOutcome(c *plannedChange) string {
+	if c.Err != nil {
+		return outcomeFailed
+	}
+
+	switch c.Action {
+	case actionCreate:
+		return outcomeCreated
+	case actionModify:
+		return outcomeUpdated
+	case actionDelete:
+		return outcomeDeleted
+	case actionReview:
+		return outcomeConflict
+	case actionSkip:
+		return outcomeSkipped
+	default:
+		return outcomeUnchanged
+	}
+}
+
+// osmWebURL derives the URL of the website from the API URL
+func osmWebURL() string {
+	u := strings.TrimSuffix(strings.TrimSuffix(cfg.OSM.APIURL, "/"), "/api/0.6")
+	return strings.Replace(u, "://api.", "://www.", 1)
+}
+
+// writeRunReport writes the report as JSON, CSV or HTML, the format is
+// detected by the file extension if not given
+func writeRunReport(filename, format string, r *runReport) error {
+	if format == "" {
+		format = strings.TrimPrefix(strings.ToLower(path.Ext(filename)), ".")
+	}
+
+	var write func(io.Writer) error
+
+	switch format {
+	case "json":
+		write = func(w io.Writer) error {
+			enc := json.NewEncoder(w)
+			enc.SetIndent("", "  ")
+			return enc.Encode(r)
+		}
+	case "csv":
+		write = func(w io.Writer) error { return writeReportCSV(w, r) }
+	case "html", "htm":
+		write = func(w io.Writer) error { return reportTemplate.Execute(w, r) }
+	default:
+		return fmt.Errorf("Unsupported report format %q", format)
+	}
+
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := write(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeReportCSV(out io.Writer, r *runReport) error {
+	w := csv.NewWriter(out)
+	if err := w.Write([]string{"waypoint", "lat", "lon", "cmt", "outcome", "reason", "node_id", "node_url", "changeset", "distance", "tags"}); err != nil {
+		return err
+	}
+
+	for _, e := range r.Entries {
+		tags := []string{}
+		for _, t := range e.Tags {
+			tags = append(tags, fmt.Sprintf("%s: %s -> %s", t.Key, t.Before, t.After))
+		}
+
+		if err := w.Write([]string{
+			e.Waypoint,
+			strconv.FormatFloat(e.Latitude, 'f', -1, 64),
+			strconv.FormatFloat(e.Longitude, 'f', -1, 64),
+			e.Comment,
+			e.Outcome,
+			e.Reason,
+			reportInt(e.NodeID),
+			e.NodeURL,
+			reportInt(e.Changeset),
+			reportFloat(e.Distance),
+			strings.Join(tags, "; "),
+		}); err != nil {
+			return err
+		}
+	}
+
+	w.Flush()
+	return w.Error()
+}
+
+func reportInt(v int64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatInt(v, 10)
+}
+
+func reportFloat(v float64) string {
+	if v == 0 {
+		return ""
+	}
+	return strconv.FormatFloat(v, 'f', -1, 64)
+}
+
+var reportTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gpxhydrant report {{ .Source }}</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #ccc; padding: 0.3em 0.6em; text-align: left; vertical-align: top; }
+th { background: #eee; }
+.created { background: #dfd; }
+.updated { background: #def; }
+.deleted { background: #fdd; }
+.skipped, .conflict { background: #ffd; }
+.failed { background: #fbb; }
+del { color: #a00; }
+ins { color: #070; text-decoration: none; }
+</style>
+</head>
+<body>
+<h1>gpxhydrant report</h1>
+<p>Source: {{ .Source }}<br>Created: {{ .CreatedAt.Format "2006-01-02 15:04:05 MST" }} by {{ .Generator }}{{ if .NoOp }}<br><strong>Dry run: no changes were sent</strong>{{ end }}</p>
+<p>{{ range $outcome, $count := .Summary }}{{ $outcome }}: {{ $count }} &nbsp; {{ end }}</p>
+<table>
+<tr><th>Waypoint</th><th>Position</th><th>Comment</th><th>Outcome</th><th>Node</th><th>Changeset</th><th>Distance</th><th>Tags</th></tr>
+{{ range .Entries }}<tr class="{{ .Outcome }}">
+<td>{{ .Waypoint }}</td>
+<td>{{ printf "%.7f" .Latitude }}, {{ printf "%.7f" .Longitude }}</td>
+<td>{{ .Comment }}</td>
+<td>{{ .Outcome }}{{ if .Reason }}<br><small>{{ .Reason }}</small>{{ end }}</td>
+<td>{{ if .NodeID }}<a href="{{ .NodeURL }}">{{ .NodeID }}</a>{{ end }}</td>
+<td>{{ if .Changeset }}{{ .Changeset }}{{ end }}</td>
+<td>{{ if .Distance }}{{ printf "%.1f" .Distance }}m{{ end }}</td>
+<td>{{ range .Tags }}{{ .Key }}: {{ if .Before }}<del>{{ .Before }}</del> {{ end }}{{ if .After }}<ins>{{ .After }}</ins>{{ end }}<br>{{ end }}</td>
+</tr>
+{{ end }}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// revertChange describes how to undo the changes of a changeset to a
+// single node
+type revertChange struct {
+	ID int64
+	// Created is set if the node was created in the changeset
+	Created bool
+	// FirstVersion and LastVersion are the first and last version of the
+	// node written in the changeset
+	FirstVersion int64
+	LastVersion  int64
+	// Node is the node to send: The current node to delete or the version
+	// before the changeset to restore
+	Node *osm.Node
+	// Conflict explains why the change can not be reverted
+	Conflict string
+}
+
+func runRevert(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("revert requires the ID of the changeset to revert")
+	}
+
+	changesetID, err := strconv.ParseInt(args[0], 10, 64)
+	if err != nil {
+		log.Fatalf("Invalid changeset ID %q: %s", args[0], err)
+	}
+
+	osmClient := newOSMClient()
+
+	oc, err := osmClient.DownloadChangeset(changesetID)
+	if err != nil {
+		log.Fatalf("Unable to download changeset %d: %s", changesetID, err)
+	}
+
+	changes := collectRevertChanges(oc)
+	if err := planRevert(osmClient, changes); err != nil {
+		log.Fatalf("Unable to plan revert: %s", err)
+	}
+
+	cfg.Comment = fmt.Sprintf("Revert changeset %d", changesetID)
+
+	conflicts, errs := 0, 0
+	for _, c := range changes {
+		if c.Conflict != "" {
+			log.Warnf("Not reverting node %d: %s", c.ID, c.Conflict)
+			conflicts++
+			continue
+		}
+
+		if err := sendRevert(osmClient, c); err != nil {
+			log.Errorf("Unable to revert node %d: %s", c.ID, err)
+			errs++
+		}
+	}
+
+	log.Infof("Reverted %d of %d nodes of changeset %d, %d conflicts", len(changes)-conflicts-errs, len(changes), changesetID, conflicts)
+	if errs > 0 {
+		log.Fatalf("%d nodes failed, run again to retry them", errs)
+	}
+}
+
+// collectRevertChanges groups all versions of the nodes in the osmChange
+func collectRevertChanges(oc *osm.OsmChange) []*revertChange {
+	var (
+		byID  = map[int64]*revertChange{}
+		order = []*revertChange{}
+	)
+
+	add := func(blocks []*osm.ChangeBlock, created bool) {
+		for _, b := range blocks {
+			for _, n := range b.Nodes {
+				c, ok := byID[n.ID]
+				if !ok {
+					c = &revertChange{ID: n.ID, FirstVersion: n.Version, LastVersion: n.Version}
+					byID[n.ID] = c
+					order = append(order, c)
+				}
+
+				c.Created = c.Created || created
+				if n.Version < c.FirstVersion {
+					c.FirstVersion = n.Version
+				}
+				if n.Version > c.LastVersion {
+					c.LastVersion = n.Version
+				}
+			}
+		}
+	}
+
+	add(oc.Create, true)
+	add(oc.Modify, false)
+	add(oc.Delete, false)
+
+	return order
+}
+
+// planRevert checks the nodes have not been edited after the changeset
+// and fetches the versions to restore
+func planRevert(osmClient *osm.Client, changes []*revertChange) error {
+	for _, c := range changes {
+		history, err := osmClient.GetNodeHistory(c.ID)
+		if err != nil {
+			return fmt.Errorf("Unable to retrieve history of node %d: %s", c.ID, err)
+		}
+
+		if len(history) == 0 {
+			c.Conflict = "no history available"
+			continue
+		}
+
+		current := history[len(history)-1]
+		if current.Version != c.LastVersion {
+			c.Conflict = fmt.Sprintf("edited again by %s in changeset %d (version %d)", current.User, current.Changeset, current.Version)
+			continue
+		}
+
+		if c.Created {
+			if !current.Visible {
+				c.Conflict = "created and deleted in the changeset"
+				continue
+			}
+			c.Node = current
+			continue
+		}
+
+		for _, n := range history {
+			if n.Version == c.FirstVersion-1 {
+				c.Node = &osm.Node{
+					ID:        c.ID,
+					Version:   current.Version,
+					Latitude:  n.Latitude,
+					Longitude: n.Longitude,
+					Tags:      n.Tags,
+				}
+			}
+		}
+
+		if c.Node == nil {
+			c.Conflict = fmt.Sprintf("version %d not found in history", c.FirstVersion-1)
+		}
+	}
+
+	return nil
+}
+
+func sendRevert(osmClient *osm.Client, c *revertChange) error {
+	if c.Created {
+		if cfg.NoOp {
+			log.Warnf("[NOOP] Would DELETE node %d created in the changeset (Changeset %d): %#v", c.ID, createChangeset(osmClient).ID, c.Node)
+			return nil
+		}
+
+		if err := osmClient.DeleteNode(c.Node, createChangeset(osmClient)); err != nil {
+			return err
+		}
+		log.Infof("Deleted node %d", c.ID)
+		return nil
+	}
+
+	var err error
+	doNoOp(
+		fmt.Sprintf("[NOOP] Would restore version %d of node %d (Changeset %d): %#v", c.FirstVersion-1, c.ID, createChangeset(osmClient).ID, c.Node),
+		func() {
+			if err = osmClient.SaveNode(c.Node, createChangeset(osmClient)); err == nil {
+				log.Infof("Restored version %d of node %d", c.FirstVersion-1, c.ID)
+			}
+		},
+	)
+
+	return err
+}
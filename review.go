@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// forcedCreate checks whether the creation of the hydrant was forced
+// globally or for one of the waypoints it was read from
+func forcedCreate(h *hydrant) bool {
+	if cfg.ForceCreate {
+		return true
+	}
+
+	for _, name := range cfg.ForceCreateFor {
+		if name == "" {
+			continue
+		}
+		if name == h.Name {
+			return true
+		}
+		for _, wp := range h.Sources {
+			if name == wp.Name {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// tagDifferences lists the surveyed tags differing from the hydrant found
+// in OSM as "key: osm -> survey"
+func tagDifferences(h, found *hydrant) []string {
+	var (
+		survey  = tagMap(h)
+		current = tagMap(found)
+		out     = []string{}
+	)
+
+	for _, k := range sortedKeys(survey) {
+		if survey[k] != current[k] {
+			out = append(out, fmt.Sprintf("%s: %q -> %q", k, current[k], survey[k]))
+		}
+	}
+
+	return out
+}
+
+// logReviewList prints all creates held back because of a nearby hydrant
+func logReviewList(changes []*plannedChange) {
+	review := []*plannedChange{}
+	for _, c := range changes {
+		if c.Action == actionReview {
+			review = append(review, c)
+		}
+	}
+
+	if len(review) == 0 {
+		return
+	}
+
+	log.Warnf("%d hydrants need review, they were not created as an existing hydrant is within %dm:", len(review), cfg.CautionRange)
+	for _, c := range review {
+		diff := "none"
+		if d := tagDifferences(c.Hydrant, c.Found); len(d) > 0 {
+			diff = strings.Join(d, ", ")
+		}
+
+		log.WithFields(log.Fields{
+			"distance": fmt.Sprintf("%.1fm", c.Distance),
+			"node":     c.Found.ID,
+			"tags":     diff,
+		}).Warnf("Needs review: waypoint %s", c.Hydrant.Name)
+	}
+	log.Warnf("Use --force-create-for=<waypoint> or --force-create to create them anyway, or raise --match-range to update the existing hydrants")
+}
@@ -0,0 +1,315 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"mime"
+	"net/http"
+	"sync"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// reviewServer serves the web UI to review the planned changes on a map
+type reviewServer struct {
+	osmClient *osm.Client
+	mapData   *osm.Wrap
+	changes   []*plannedChange
+	skipped   []skippedWaypoint
+
+	// token needs to be sent with the apply request to prevent other
+	// websites from applying the changes through the browser
+	token string
+
+	lock    sync.Mutex
+	applied bool
+}
+
+type serveConfig struct {
+	TileURL     string `json:"tile_url"`
+	Attribution string `json:"attribution"`
+	NoOp        bool   `json:"noop"`
+}
+
+type serveChange struct {
+	ID        int          `json:"id"`
+	Action    changeAction `json:"action"`
+	Outcome   string       `json:"outcome,omitempty"`
+	Waypoint  string       `json:"waypoint"`
+	Latitude  float64      `json:"lat"`
+	Longitude float64      `json:"lon"`
+	NodeID    int64        `json:"node_id,omitempty"`
+	NodeLat   float64      `json:"node_lat,omitempty"`
+	NodeLon   float64      `json:"node_lon,omitempty"`
+	Distance  float64      `json:"distance,omitempty"`
+	Reason    string       `json:"reason,omitempty"`
+	Tags      []string     `json:"tags"`
+	Accepted  bool         `json:"accepted"`
+}
+
+type serveSkipped struct {
+	Waypoint   string  `json:"waypoint"`
+	Latitude   float64 `json:"lat"`
+	Longitude  float64 `json:"lon"`
+	Reason     string  `json:"reason"`
+	Suggestion string  `json:"suggestion,omitempty"`
+}
+
+type serveOutline struct {
+	Kind        string       `json:"kind"`
+	Coordinates [][2]float64 `json:"coordinates"`
+}
+
+func runServe() {
+	requireGPXFile()
+	validateRemovalMode()
+
+	hydrants, skipped, bds := hydrantsFromGPXFile()
+	osmClient := newOSMClient()
+	mapData := retrieveMapData(osmClient, bds, mapDataBorder)
+
+	token, err := newServeToken()
+	if err != nil {
+		log.Fatalf("Unable to create token: %s", err)
+	}
+
+	// The review happens in the browser
+	cfg.Interactive = false
+
+	s := &reviewServer{
+		osmClient: osmClient,
+		mapData:   mapData,
+		changes:   planHydrants(hydrants, hydrantsFromMapData(mapData), osmClient),
+		skipped:   skipped,
+		token:     token,
+	}
+
+	http.HandleFunc("/", s.handleAsset)
+	http.HandleFunc("/api/config", s.handleConfig)
+	http.HandleFunc("/api/changes", s.handleChanges)
+	http.HandleFunc("/api/outlines", s.handleOutlines)
+	http.HandleFunc("/api/apply", s.handleApply)
+
+	log.Infof("Serving review UI on http://%s/", cfg.Listen)
+	log.Fatalf("HTTP server failed: %s", http.ListenAndServe(cfg.Listen, nil))
+}
+
+func newServeToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func (s *reviewServer) handleAsset(res http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/" || r.URL.Path == "/index.html" {
+		s.handleIndex(res, r)
+		return
+	}
+
+	asset, ok := serveAssets[r.URL.Path]
+	if !ok {
+		http.NotFound(res, r)
+		return
+	}
+
+	res.Header().Set("Content-Type", asset.ContentType)
+	res.Write([]byte(asset.Content))
+}
+
+func (s *reviewServer) handleIndex(res http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Token   string         `json:"token"`
+		Skipped []serveSkipped `json:"skipped"`
+	}{Token: s.token, Skipped: []serveSkipped{}}
+
+	for _, sw := range s.skipped {
+		data.Skipped = append(data.Skipped, serveSkipped{
+			Waypoint:   sw.Waypoint.Name,
+			Latitude:   sw.Waypoint.Latitude,
+			Longitude:  sw.Waypoint.Longitude,
+			Reason:     sw.Reason,
+			Suggestion: sw.Suggestion,
+		})
+	}
+
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := serveIndexTemplate.Execute(res, data); err != nil {
+		log.Errorf("Unable to render index: %s", err)
+	}
+}
+
+func (s *reviewServer) handleConfig(res http.ResponseWriter, r *http.Request) {
+	writeJSON(res, http.StatusOK, serveConfig{
+		TileURL:     cfg.TileURL,
+		Attribution: cfg.TileAttribution,
+		NoOp:        cfg.NoOp,
+	})
+}
+
+func (s *reviewServer) handleChanges(res http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	out := []serveChange{}
+	for i, c := range s.changes {
+		sc := serveChange{
+			ID:        i,
+			Action:    c.Action,
+			Waypoint:  c.Hydrant.Name,
+			Latitude:  c.Hydrant.Latitude,
+			Longitude: c.Hydrant.Longitude,
+			Distance:  roundPrec(c.Distance, 1),
+			Reason:    c.Reason,
+			Tags:      []string{},
+			Accepted:  c.Action == actionCreate || c.Action == actionModify || c.Action == actionDelete,
+		}
+
+		if s.applied {
+			sc.Outcome = changeOutcome(c)
+			if c.Err != nil {
+				sc.Reason = c.Err.Error()
+			}
+		}
+
+		if c.Found != nil {
+			sc.NodeID, sc.NodeLat, sc.NodeLon = c.Found.ID, c.Found.Latitude, c.Found.Longitude
+		}
+
+		switch {
+		case c.Action == actionReview:
+			sc.Tags = tagDiff(nil, tagMap(c.Hydrant), false)
+		case c.Node != nil:
+			var before map[string]string
+			if c.Found != nil {
+				before = tagMap(c.Found)
+			}
+			sc.Tags = tagDiff(before, nodeTagMap(c.Node), c.Action == actionDelete)
+		}
+
+		out = append(out, sc)
+	}
+
+	writeJSON(res, http.StatusOK, out)
+}
+
+// handleOutlines returns streets, buildings and water areas from the map
+// data to draw a map without tile server
+func (s *reviewServer) handleOutlines(res http.ResponseWriter, r *http.Request) {
+	nodes := map[int64]*osm.Node{}
+	for _, n := range s.mapData.Nodes {
+		nodes[n.ID] = n
+	}
+
+	out := []serveOutline{}
+	for _, w := range s.mapData.Ways {
+		kind := ""
+		for _, t := range w.Tags {
+			switch t.Key {
+			case "highway", "building", "waterway":
+				kind = t.Key
+			case "natural":
+				if t.Value == "water" {
+					kind = "waterway"
+				}
+			}
+		}
+		if kind == "" {
+			continue
+		}
+
+		o := serveOutline{Kind: kind}
+		for _, ref := range w.NodeRefs {
+			if n, ok := nodes[ref.Ref]; ok {
+				o.Coordinates = append(o.Coordinates, [2]float64{n.Latitude, n.Longitude})
+			}
+		}
+		if len(o.Coordinates) > 1 {
+			out = append(out, o)
+		}
+	}
+
+	writeJSON(res, http.StatusOK, out)
+}
+
+// handleApply sends all changes accepted in the UI, all other changes are
+// skipped. The plan can only be applied once.
+func (s *reviewServer) handleApply(res http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeJSON(res, http.StatusMethodNotAllowed, map[string]string{"error": "POST required"})
+		return
+	}
+
+	// Other websites can send form data or plain text to this server but
+	// can neither set the content type to JSON nor read the token
+	if mt, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mt != "application/json" {
+		writeJSON(res, http.StatusUnsupportedMediaType, map[string]string{"error": "JSON body required"})
+		return
+	}
+	if subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Apply-Token")), []byte(s.token)) != 1 {
+		writeJSON(res, http.StatusForbidden, map[string]string{"error": "Invalid token"})
+		return
+	}
+
+	req := struct {
+		Accepted []int `json:"accepted"`
+	}{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(res, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.applied {
+		writeJSON(res, http.StatusConflict, map[string]string{"error": "Changes were already applied"})
+		return
+	}
+
+	j, err := openJournal(cfg.JournalFile)
+	if err != nil {
+		writeJSON(res, http.StatusInternalServerError, map[string]string{"error": err.Error()})
+		return
+	}
+
+	accepted := map[int]bool{}
+	for _, id := range req.Accepted {
+		accepted[id] = true
+	}
+
+	for i, c := range s.changes {
+		if !accepted[i] {
+			skipReviewed(c)
+			continue
+		}
+
+		if c.Action == actionReview {
+			// Create held back for review accepted in the UI
+			planCreate(c)
+		}
+	}
+
+	_, errs := applyChanges(s.changes, s.osmClient, j)
+	s.applied = true
+
+	msgs := []string{}
+	for _, e := range errs {
+		msgs = append(msgs, e.Error())
+	}
+
+	writeJSON(res, http.StatusOK, map[string]interface{}{"errors": msgs})
+}
+
+func writeJSON(res http.ResponseWriter, status int, data interface{}) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(status)
+	if err := json.NewEncoder(res).Encode(data); err != nil {
+		log.Errorf("Unable to encode response: %s", err)
+	}
+}
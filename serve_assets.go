@@ -0,0 +1,395 @@
+package main
+
+// Static assets of the review UI served by the serve command. They are
+// kept in the binary to have a single file to distribute and to work
+// without internet connection.
+
+import "html/template"
+
+type serveAsset struct {
+	ContentType string
+	Content     string
+}
+
+var serveAssets = map[string]serveAsset{
+	"/app.css": {ContentType: "text/css; charset=utf-8", Content: serveAppCSS},
+	"/app.js":  {ContentType: "application/javascript; charset=utf-8", Content: serveAppJS},
+}
+
+// serveIndexTemplate is rendered with the apply token and the skipped
+// waypoints of the run
+var serveIndexTemplate = template.Must(template.New("index").Parse(serveIndexHTML))
+
+const serveIndexHTML = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>gpxhydrant review</title>
+<link rel="stylesheet" href="/app.css">
+</head>
+<body>
+<div id="map"><canvas id="canvas"></canvas><div id="attribution"></div></div>
+<div id="sidebar">
+  <h1>gpxhydrant review</h1>
+  <p id="status"></p>
+  <p>
+    <button id="apply">Apply accepted changes</button>
+  </p>
+  <div id="changes"></div>
+  <div id="skipped"></div>
+</div>
+<script>var reviewData = {{ . }};</script>
+<script src="/app.js"></script>
+</body>
+</html>
+`
+
+const serveAppCSS = `html, body { margin: 0; height: 100%; font-family: sans-serif; font-size: 14px; }
+body { display: flex; }
+#map { flex: 1; position: relative; overflow: hidden; background: #f2efe9; }
+#canvas { position: absolute; top: 0; left: 0; cursor: grab; }
+#attribution { position: absolute; right: 0; bottom: 0; background: rgba(255,255,255,0.7); padding: 2px 5px; font-size: 11px; }
+#sidebar { width: 380px; overflow-y: auto; padding: 0 1em; border-left: 1px solid #ccc; }
+h1 { font-size: 18px; }
+h2 { font-size: 15px; }
+.change { border: 1px solid #ccc; border-left-width: 6px; margin: 0.5em 0; padding: 0.4em; cursor: pointer; }
+.change.selected { background: #ffc; }
+.change.create { border-left-color: #2a2; }
+.change.modify { border-left-color: #27c; }
+.change.delete { border-left-color: #c22; }
+.change.review { border-left-color: #e90; }
+.change.skip, .change.none, .change.invalid { border-left-color: #999; color: #666; }
+.change pre { margin: 0.3em 0 0; font-size: 12px; white-space: pre-wrap; }
+.outcome { font-weight: bold; }
+button { font-size: 14px; padding: 0.3em 1em; }
+`
+
+const serveAppJS = `(function () {
+  'use strict';
+
+  var canvas = document.getElementById('canvas');
+  var ctx = canvas.getContext('2d');
+  var state = { lat: 0, lon: 0, zoom: 17, changes: [], skipped: reviewData.skipped || [], outlines: [], selected: -1, config: {}, tiles: {} };
+  var colors = { create: '#2a2', modify: '#27c', delete: '#c22', review: '#e90', skip: '#999', none: '#999' };
+
+  function getJSON(url, cb) {
+    var xhr = new XMLHttpRequest();
+    xhr.open('GET', url);
+    xhr.onload = function () { cb(JSON.parse(xhr.responseText)); };
+    xhr.send();
+  }
+
+  // Web mercator projection into world pixels at the current zoom
+  function project(lat, lon) {
+    var scale = 256 * Math.pow(2, state.zoom);
+    var sin = Math.sin(lat * Math.PI / 180);
+    return {
+      x: (lon + 180) / 360 * scale,
+      y: (0.5 - Math.log((1 + sin) / (1 - sin)) / (4 * Math.PI)) * scale
+    };
+  }
+
+  function unproject(x, y) {
+    var scale = 256 * Math.pow(2, state.zoom);
+    var n = Math.PI - 2 * Math.PI * y / scale;
+    return {
+      lat: 180 / Math.PI * Math.atan(0.5 * (Math.exp(n) - Math.exp(-n))),
+      lon: x / scale * 360 - 180
+    };
+  }
+
+  function toScreen(lat, lon) {
+    var c = project(state.lat, state.lon), p = project(lat, lon);
+    return { x: p.x - c.x + canvas.width / 2, y: p.y - c.y + canvas.height / 2 };
+  }
+
+  function tileURL(z, x, y) {
+    return state.config.tile_url.replace('{z}', z).replace('{x}', x).replace('{y}', y);
+  }
+
+  function drawTiles() {
+    var c = project(state.lat, state.lon);
+    var left = c.x - canvas.width / 2, top = c.y - canvas.height / 2;
+    var max = Math.pow(2, state.zoom);
+
+    for (var tx = Math.floor(left / 256); tx <= Math.floor((left + canvas.width) / 256); tx++) {
+      for (var ty = Math.floor(top / 256); ty <= Math.floor((top + canvas.height) / 256); ty++) {
+        if (ty < 0 || ty >= max) { continue; }
+        var url = tileURL(state.zoom, ((tx % max) + max) % max, ty);
+        var img = state.tiles[url];
+        if (!img) {
+          img = new Image();
+          img.onload = draw;
+          img.src = url;
+          state.tiles[url] = img;
+        }
+        if (img.complete && img.naturalWidth > 0) {
+          ctx.drawImage(img, tx * 256 - left, ty * 256 - top);
+        }
+      }
+    }
+  }
+
+  function drawOutlines() {
+    state.outlines.forEach(function (o) {
+      ctx.beginPath();
+      o.coordinates.forEach(function (c, i) {
+        var p = toScreen(c[0], c[1]);
+        if (i === 0) { ctx.moveTo(p.x, p.y); } else { ctx.lineTo(p.x, p.y); }
+      });
+      if (o.kind === 'building') {
+        ctx.fillStyle = '#d9d0c9';
+        ctx.fill();
+        ctx.strokeStyle = '#b9a99c';
+        ctx.lineWidth = 1;
+      } else if (o.kind === 'waterway') {
+        ctx.strokeStyle = '#9cc3e6';
+        ctx.lineWidth = 3;
+      } else {
+        ctx.strokeStyle = '#fff';
+        ctx.lineWidth = 6;
+        ctx.stroke();
+        ctx.strokeStyle = '#bbb';
+        ctx.lineWidth = 4;
+      }
+      ctx.stroke();
+    });
+  }
+
+  function draw() {
+    canvas.width = canvas.parentNode.clientWidth;
+    canvas.height = canvas.parentNode.clientHeight;
+    ctx.fillStyle = '#f2efe9';
+    ctx.fillRect(0, 0, canvas.width, canvas.height);
+
+    if (state.config.tile_url) { drawTiles(); } else { drawOutlines(); }
+
+    state.changes.forEach(function (c) {
+      var wp = toScreen(c.lat, c.lon);
+      var color = colors[c.action] || '#999';
+
+      if (c.node_id) {
+        var n = toScreen(c.node_lat, c.node_lon);
+        ctx.strokeStyle = color;
+        ctx.lineWidth = 2;
+        ctx.setLineDash([4, 3]);
+        ctx.beginPath();
+        ctx.moveTo(wp.x, wp.y);
+        ctx.lineTo(n.x, n.y);
+        ctx.stroke();
+        ctx.setLineDash([]);
+
+        ctx.fillStyle = '#fff';
+        ctx.strokeStyle = '#333';
+        ctx.lineWidth = 1;
+        ctx.fillRect(n.x - 4, n.y - 4, 8, 8);
+        ctx.strokeRect(n.x - 4, n.y - 4, 8, 8);
+      }
+
+      ctx.beginPath();
+      ctx.arc(wp.x, wp.y, c.id === state.selected ? 9 : 6, 0, 2 * Math.PI);
+      ctx.fillStyle = color;
+      ctx.globalAlpha = c.accepted || c.outcome ? 1 : 0.4;
+      ctx.fill();
+      ctx.globalAlpha = 1;
+      ctx.strokeStyle = '#000';
+      ctx.lineWidth = 1;
+      ctx.stroke();
+    });
+
+    // Waypoints without a hydrant are drawn as crosses
+    state.skipped.forEach(function (s) {
+      var p = toScreen(s.lat, s.lon);
+      ctx.strokeStyle = '#666';
+      ctx.lineWidth = 2;
+      ctx.beginPath();
+      ctx.moveTo(p.x - 5, p.y - 5);
+      ctx.lineTo(p.x + 5, p.y + 5);
+      ctx.moveTo(p.x + 5, p.y - 5);
+      ctx.lineTo(p.x - 5, p.y + 5);
+      ctx.stroke();
+    });
+  }
+
+  function renderSkipped() {
+    var list = document.getElementById('skipped');
+    list.innerHTML = '';
+    if (state.skipped.length === 0) { return; }
+
+    var h = document.createElement('h2');
+    h.textContent = state.skipped.length + ' skipped waypoints';
+    list.appendChild(h);
+
+    state.skipped.forEach(function (s) {
+      var div = document.createElement('div');
+      div.className = 'change invalid';
+      div.textContent = 'Waypoint ' + s.waypoint + ': ' + s.reason + (s.suggestion ? ' (' + s.suggestion + ')' : '');
+      div.onclick = function () { state.lat = s.lat; state.lon = s.lon; draw(); };
+      list.appendChild(div);
+    });
+  }
+
+  function renderList() {
+    var list = document.getElementById('changes');
+    list.innerHTML = '';
+
+    state.changes.forEach(function (c) {
+      var div = document.createElement('div');
+      div.className = 'change ' + c.action + (c.id === state.selected ? ' selected' : '');
+      div.id = 'change-' + c.id;
+
+      var title = document.createElement('div');
+      var text = 'Waypoint ' + c.waypoint + ': ' + c.action;
+      if (c.node_id) { text += ' node ' + c.node_id + ' (' + c.distance + 'm)'; }
+      title.textContent = text;
+
+      var actionable = ['create', 'modify', 'delete', 'review'].indexOf(c.action) >= 0;
+      if (c.outcome) {
+        var outcome = document.createElement('span');
+        outcome.className = 'outcome';
+        outcome.textContent = ' => ' + c.outcome;
+        title.appendChild(outcome);
+      } else if (actionable) {
+        var label = document.createElement('label');
+        var box = document.createElement('input');
+        box.type = 'checkbox';
+        box.checked = c.accepted;
+        box.onclick = function (e) {
+          e.stopPropagation();
+          c.accepted = box.checked;
+          draw();
+        };
+        label.appendChild(box);
+        label.appendChild(document.createTextNode(' accept'));
+        label.onclick = function (e) { e.stopPropagation(); };
+        title.appendChild(document.createTextNode(' '));
+        title.appendChild(label);
+      }
+      div.appendChild(title);
+
+      if (c.reason) {
+        var reason = document.createElement('div');
+        reason.textContent = c.reason;
+        div.appendChild(reason);
+      }
+
+      if (c.tags.length > 0) {
+        var pre = document.createElement('pre');
+        pre.textContent = c.tags.join('\n');
+        div.appendChild(pre);
+      }
+
+      div.onclick = function () { select(c.id, true); };
+      list.appendChild(div);
+    });
+  }
+
+  function select(id, center) {
+    state.selected = id;
+    var c = state.changes[id];
+    if (center) { state.lat = c.lat; state.lon = c.lon; }
+    renderList();
+    draw();
+    var el = document.getElementById('change-' + id);
+    if (el && !center) { el.scrollIntoView(); }
+  }
+
+  function load() {
+    getJSON('/api/changes', function (changes) {
+      state.changes = changes;
+      if (changes.length > 0 && state.lat === 0) {
+        var lat = 0, lon = 0;
+        changes.forEach(function (c) { lat += c.lat; lon += c.lon; });
+        state.lat = lat / changes.length;
+        state.lon = lon / changes.length;
+      }
+
+      var applied = changes.some(function (c) { return c.outcome; });
+      document.getElementById('apply').disabled = applied;
+      document.getElementById('status').textContent = applied ? 'Changes were applied.' :
+        changes.length + ' waypoints' + (state.config.noop ? ' (noop mode: nothing will be written)' : '');
+
+      renderList();
+      draw();
+    });
+  }
+
+  document.getElementById('apply').onclick = function () {
+    var accepted = state.changes.filter(function (c) { return c.accepted; }).map(function (c) { return c.id; });
+    if (!window.confirm('Send ' + accepted.length + ' accepted changes to OpenStreetMap?')) { return; }
+
+    var xhr = new XMLHttpRequest();
+    xhr.open('POST', '/api/apply');
+    xhr.setRequestHeader('Content-Type', 'application/json');
+    xhr.setRequestHeader('X-Apply-Token', reviewData.token);
+    xhr.onload = function () {
+      var res = JSON.parse(xhr.responseText);
+      if (res.error) { window.alert(res.error); }
+      if (res.errors && res.errors.length > 0) { window.alert('Some changes failed:\n' + res.errors.join('\n')); }
+      load();
+    };
+    xhr.send(JSON.stringify({ accepted: accepted }));
+  };
+
+  // Panning and zooming
+  var drag = null;
+  canvas.onmousedown = function (e) { drag = { x: e.clientX, y: e.clientY, moved: false }; };
+  window.onmouseup = function (e) {
+    if (drag && !drag.moved) { clickAt(e); }
+    drag = null;
+  };
+  window.onmousemove = function (e) {
+    if (!drag) { return; }
+    var c = project(state.lat, state.lon);
+    var dx = e.clientX - drag.x, dy = e.clientY - drag.y;
+    if (Math.abs(dx) + Math.abs(dy) > 2) { drag.moved = true; }
+    var ll = unproject(c.x - dx, c.y - dy);
+    state.lat = ll.lat;
+    state.lon = ll.lon;
+    drag.x = e.clientX;
+    drag.y = e.clientY;
+    draw();
+  };
+  canvas.onwheel = function (e) {
+    e.preventDefault();
+    var zoom = Math.max(2, Math.min(21, state.zoom + (e.deltaY < 0 ? 1 : -1)));
+    if (zoom === state.zoom) { return; }
+
+    // Keep the position below the cursor in place
+    var rect = canvas.getBoundingClientRect();
+    var mx = e.clientX - rect.left - canvas.width / 2, my = e.clientY - rect.top - canvas.height / 2;
+    var c = project(state.lat, state.lon);
+    var cursor = unproject(c.x + mx, c.y + my);
+    state.zoom = zoom;
+    var p = project(cursor.lat, cursor.lon);
+    var ll = unproject(p.x - mx, p.y - my);
+    state.lat = ll.lat;
+    state.lon = ll.lon;
+    draw();
+  };
+
+  function clickAt(e) {
+    var rect = canvas.getBoundingClientRect();
+    var x = e.clientX - rect.left, y = e.clientY - rect.top;
+    var best = -1, bestDist = 100;
+    state.changes.forEach(function (c) {
+      var p = toScreen(c.lat, c.lon);
+      var d = (p.x - x) * (p.x - x) + (p.y - y) * (p.y - y);
+      if (d < bestDist) { best = c.id; bestDist = d; }
+    });
+    if (best >= 0) { select(best, false); }
+  }
+
+  window.onresize = draw;
+
+  getJSON('/api/config', function (config) {
+    state.config = config;
+    document.getElementById('attribution').textContent = config.attribution || '';
+    if (!config.tile_url) {
+      getJSON('/api/outlines', function (outlines) { state.outlines = outlines; draw(); });
+    }
+    renderSkipped();
+    load();
+  });
+})();
+`
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"time"
+)
+
+var surveyDateFormats = []string{"2006-01-02", "2006-01", "2006"}
+
+// applySurveyDate sets the survey date tag of the hydrant from the time
+// of its waypoint unless the found hydrant already has the same or a
+// newer date. It returns whether the tag was set.
+func applySurveyDate(h, found *hydrant) bool {
+	if cfg.CheckDateTag == "" || h.SurveyDate.IsZero() {
+		return false
+	}
+
+	surveyDay := h.SurveyDate.UTC().Truncate(24 * time.Hour)
+
+	if found != nil {
+		if existing, ok := parseSurveyDate(found.Tags[cfg.CheckDateTag]); ok && !existing.Before(surveyDay) {
+			return false
+		}
+	}
+
+	if h.Tags == nil {
+		h.Tags = map[string]string{}
+	}
+	h.Tags[cfg.CheckDateTag] = surveyDay.Format(surveyDateFormats[0])
+
+	return true
+}
+
+// surveyDateExpired checks whether the survey date of the hydrant is
+// missing or older than the configured check-date-age
+func surveyDateExpired(h *hydrant) bool {
+	existing, ok := parseSurveyDate(h.Tags[cfg.CheckDateTag])
+	if !ok {
+		return true
+	}
+
+	return existing.Before(time.Now().AddDate(0, 0, -int(cfg.CheckDateAge)))
+}
+
+func parseSurveyDate(value string) (time.Time, bool) {
+	for _, f := range surveyDateFormats {
+		if t, err := time.Parse(f, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
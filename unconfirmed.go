@@ -0,0 +1,237 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/Luzifer/gpxhydrant/gpx"
+	log "github.com/Sirupsen/logrus"
+)
+
+// earthRadius is the mean earth radius in meters used to project
+// positions onto a plane for short distances
+const earthRadius = 6371000.0
+
+// surveyCorridor is the area within a fixed width around the recorded
+// tracks in which all hydrants are expected to be surveyed
+type surveyCorridor struct {
+	// segments contains the track segments as longitude, latitude pairs
+	segments [][][2]float64
+	width    float64
+}
+
+// unconfirmedHydrant is an OSM hydrant inside the survey corridor no
+// surveyed hydrant was matched to
+type unconfirmedHydrant struct {
+	Hydrant *hydrant
+	// Distance is the distance to the track in meters
+	Distance float64
+	// Nearest is the distance to the nearest surveyed hydrant in meters
+	Nearest float64
+}
+
+func runAnalyzeUnconfirmed() {
+	requireGPXFile()
+
+	gpxData, err := readWaypoints(cfg.GPXFile, cfg.InputFormat)
+	if err != nil {
+		log.Fatalf("Unable to read your GPX file: %s", err)
+	}
+
+	corridor := newSurveyCorridor(gpxData.Tracks, float64(cfg.CorridorWidth))
+	if len(corridor.segments) == 0 {
+		log.Fatalf("The GPX file contains no tracks to build the survey corridor from")
+	}
+
+	hydrants, _, _ := hydrantsFromGPXFile()
+	osmClient := newOSMClient()
+
+	unconfirmed := findUnconfirmedHydrants(corridor, hydrants, surveyArea.Hydrants(getHydrantsFromOSM(osmClient, corridor.Bounds())), float64(cfg.MachRange))
+	writeUnconfirmedReport(os.Stdout, unconfirmed)
+
+	if cfg.UnconfirmedFile == "" {
+		return
+	}
+
+	f, err := os.Create(cfg.UnconfirmedFile)
+	if err != nil {
+		log.Fatalf("Unable to create unconfirmed file: %s", err)
+	}
+	defer f.Close()
+
+	if err := gpx.WriteGPXData(f, unconfirmedWaypoints(unconfirmed), "gpxhydrant"); err != nil {
+		log.Fatalf("Unable to write unconfirmed file: %s", err)
+	}
+}
+
+func newSurveyCorridor(tracks []gpx.Track, width float64) *surveyCorridor {
+	c := &surveyCorridor{width: width}
+	for _, t := range tracks {
+		for _, s := range t.Segments {
+			points := [][2]float64{}
+			for _, p := range s.Points {
+				if surveyArea.Contains(p.Latitude, p.Longitude) {
+					points = append(points, [2]float64{p.Longitude, p.Latitude})
+					continue
+				}
+
+				// Leaving the area splits the segment
+				if len(points) > 0 {
+					c.segments = append(c.segments, points)
+				}
+				points = [][2]float64{}
+			}
+			if len(points) > 0 {
+				c.segments = append(c.segments, points)
+			}
+		}
+	}
+	return c
+}
+
+// Bounds returns the bounding box of the tracks extended by the width
+// of the corridor
+func (c *surveyCorridor) Bounds() bounds {
+	bds := bounds{MinLat: 9999, MinLon: 9999, MaxLat: -9999, MaxLon: -9999}
+	for _, s := range c.segments {
+		for _, p := range s {
+			bds.Update(p[1], p[0])
+		}
+	}
+
+	dLat := c.width / earthRadius * 180 / math.Pi
+	dLon := dLat / math.Cos(math.Max(math.Abs(bds.MinLat), math.Abs(bds.MaxLat))*math.Pi/180)
+
+	return bounds{
+		MinLat: bds.MinLat - dLat,
+		MinLon: bds.MinLon - dLon,
+		MaxLat: bds.MaxLat + dLat,
+		MaxLon: bds.MaxLon + dLon,
+	}
+}
+
+// Distance returns the distance in meters of the position to the
+// nearest track segment
+func (c *surveyCorridor) Distance(lat, lon float64) float64 {
+	dist := math.Inf(1)
+	for _, s := range c.segments {
+		if len(s) == 1 {
+			dist = math.Min(dist, segmentDistance(lat, lon, s[0], s[0]))
+		}
+		for i := 1; i < len(s); i++ {
+			dist = math.Min(dist, segmentDistance(lat, lon, s[i-1], s[i]))
+		}
+	}
+	return dist
+}
+
+// segmentDistance returns the distance in meters of the position to the
+// line between a and b. The positions are projected onto a plane around
+// the position which is precise enough for the short track segments.
+func segmentDistance(lat, lon float64, a, b [2]float64) float64 {
+	scale := math.Cos(lat * math.Pi / 180)
+	project := func(p [2]float64) (float64, float64) {
+		return (p[0] - lon) * scale * math.Pi / 180 * earthRadius, (p[1] - lat) * math.Pi / 180 * earthRadius
+	}
+
+	ax, ay := project(a)
+	bx, by := project(b)
+	dx, dy := bx-ax, by-ay
+
+	// Position of the nearest point on the line between a (0) and b (1)
+	t := 0.0
+	if l := dx*dx + dy*dy; l > 0 {
+		t = math.Max(0, math.Min(1, -(ax*dx+ay*dy)/l))
+	}
+
+	return math.Hypot(ax+t*dx, ay+t*dy)
+}
+
+// findUnconfirmedHydrants returns the OSM hydrants within the corridor
+// having no surveyed hydrant within the match range, sorted by ID
+func findUnconfirmedHydrants(corridor *surveyCorridor, surveyed, available []*hydrant, matchRange float64) []*unconfirmedHydrant {
+	out := []*unconfirmedHydrant{}
+
+	for _, a := range available {
+		dist := corridor.Distance(a.Latitude, a.Longitude)
+		if dist > corridor.width {
+			continue
+		}
+
+		if found, _ := nearestHydrant(a, surveyed, matchRange); found != nil {
+			continue
+		}
+
+		out = append(out, &unconfirmedHydrant{
+			Hydrant:  a,
+			Distance: dist,
+			Nearest:  nearestHydrantDistance(a.Latitude, a.Longitude, surveyed),
+		})
+	}
+
+	sort.Slice(out, func(i, j int) bool { return out[i].Hydrant.ID < out[j].Hydrant.ID })
+
+	return out
+}
+
+func writeUnconfirmedReport(out io.Writer, unconfirmed []*unconfirmedHydrant) {
+	if len(unconfirmed) == 0 {
+		fmt.Fprintf(out, "All hydrants within %dm of the tracks were confirmed by a waypoint\n", cfg.CorridorWidth)
+		return
+	}
+
+	fmt.Fprintf(out, "%d hydrants within %dm of the tracks were not confirmed by a waypoint:\n\n", len(unconfirmed), cfg.CorridorWidth)
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Node\tLatitude\tLongitude\tType\tRef\tTo track\tTo waypoint\tLast edit\t")
+	for _, u := range unconfirmed {
+		h := u.Hydrant
+		fmt.Fprintf(tw, "%d\t%.6f\t%.6f\t%s\t%s\t%.0fm\t%s\t%s\t\n",
+			h.ID, h.Latitude, h.Longitude, h.Type, h.Tags["ref"], u.Distance, formatUnconfirmedDistance(u.Nearest), formatLastEdit(h))
+	}
+	tw.Flush()
+}
+
+func formatUnconfirmedDistance(d float64) string {
+	if math.IsInf(d, 1) {
+		return "-"
+	}
+	return fmt.Sprintf("%.0fm", d)
+}
+
+func formatLastEdit(h *hydrant) string {
+	if h.LastEdit.IsZero() {
+		return "-"
+	}
+	return fmt.Sprintf("%s by %s", h.LastEdit.Format("2006-01-02"), h.LastEditUser)
+}
+
+// unconfirmedWaypoints converts the hydrants into waypoints named by
+// their node ID to be loaded into a GPS device for a follow-up visit
+func unconfirmedWaypoints(unconfirmed []*unconfirmedHydrant) *gpx.GPX {
+	out := &gpx.GPX{}
+	for _, u := range unconfirmed {
+		h := u.Hydrant
+
+		desc := fmt.Sprintf("Unconfirmed hydrant %s/node/%d", osmWebURL(), h.ID)
+		if h.Type != "" {
+			desc += ", type " + h.Type
+		}
+		if ref := h.Tags["ref"]; ref != "" {
+			desc += ", ref " + ref
+		}
+
+		out.Waypoints = append(out.Waypoints, gpx.Waypoint{
+			Latitude:    h.Latitude,
+			Longitude:   h.Longitude,
+			Name:        fmt.Sprintf("%d", h.ID),
+			Description: desc,
+			Type:        "fire_hydrant",
+		})
+	}
+	return out
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+func TestSegmentDistance(t *testing.T) {
+	// Segment along the latitude 53.6 from 9.70 to 9.71
+	a, b := [2]float64{9.70, 53.6}, [2]float64{9.71, 53.6}
+
+	for _, c := range []struct {
+		Name     string
+		Lat, Lon float64
+		Expected float64
+	}{
+		{"on the segment", 53.6, 9.705, 0},
+		{"north of the segment", 53.6001, 9.705, 11.1},
+		{"south of the segment", 53.5998, 9.702, 22.2},
+		{"beyond the end", 53.6, 9.7105, 33.1},
+		{"before the start", 53.6, 9.6995, 33.1},
+		{"diagonal to the start", 53.6001, 9.69985, 14.8},
+	} {
+		if got := segmentDistance(c.Lat, c.Lon, a, b); math.Abs(got-c.Expected) > 0.2 {
+			t.Errorf("%s: expected %.1fm, got %.1fm", c.Name, c.Expected, got)
+		}
+	}
+
+	// Segments of a single point are the distance to that point
+	if got := segmentDistance(53.6001, 9.70, a, a); math.Abs(got-11.1) > 0.2 {
+		t.Errorf("single point: expected 11.1m, got %.1fm", got)
+	}
+}
+
+func TestFindUnconfirmedHydrants(t *testing.T) {
+	corridor := &surveyCorridor{
+		segments: [][][2]float64{{{9.70, 53.6}, {9.71, 53.6}}},
+		width:    20,
+	}
+
+	surveyed := []*hydrant{{Latitude: 53.6, Longitude: 9.701}}
+	available := []*hydrant{
+		{ID: 1, Latitude: 53.60002, Longitude: 9.701}, // Confirmed by the waypoint
+		{ID: 2, Latitude: 53.6001, Longitude: 9.705},  // Next to the track
+		{ID: 3, Latitude: 53.601, Longitude: 9.705},   // Outside the corridor
+	}
+
+	unconfirmed := findUnconfirmedHydrants(corridor, surveyed, available, 5)
+	if len(unconfirmed) != 1 || unconfirmed[0].Hydrant.ID != 2 {
+		t.Fatalf("Expected hydrant 2 to be unconfirmed, got %#v", unconfirmed)
+	}
+	if math.Abs(unconfirmed[0].Distance-11.1) > 0.2 {
+		t.Errorf("Expected distance of 11.1m to the track, got %.1fm", unconfirmed[0].Distance)
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// verifyMismatch describes a difference between a node sent to the API
+// and the node retrieved afterwards
+type verifyMismatch struct {
+	NodeID   int64
+	Waypoint string
+	Field    string
+	Expected string
+	Actual   string
+}
+
+// verifyChanges downloads every node sent in this run and compares it to
+// the node which was sent. If a node was sent multiple times only the
+// last change is verified.
+func verifyChanges(changes []*plannedChange, osmClient *osm.Client) ([]verifyMismatch, int, error) {
+	var (
+		sent = map[int64]*plannedChange{}
+		ids  = []int64{}
+	)
+
+	for _, c := range changes {
+		if c.Changeset == 0 {
+			continue
+		}
+		if _, ok := sent[c.Node.ID]; !ok {
+			ids = append(ids, c.Node.ID)
+		}
+		sent[c.Node.ID] = c
+	}
+
+	nodes, err := osmClient.GetNodes(ids)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	live := map[int64]*osm.Node{}
+	for _, n := range nodes {
+		live[n.ID] = n
+	}
+
+	mismatches := []verifyMismatch{}
+	for _, id := range ids {
+		mismatches = append(mismatches, verifyNode(sent[id], live[id])...)
+	}
+
+	return mismatches, len(ids), nil
+}
+
+func verifyNode(c *plannedChange, n *osm.Node) []verifyMismatch {
+	out := []verifyMismatch{}
+	add := func(field, expected, actual string) {
+		out = append(out, verifyMismatch{NodeID: c.Node.ID, Waypoint: c.Hydrant.Name, Field: field, Expected: expected, Actual: actual})
+	}
+
+	if n == nil {
+		add("node", "present", "missing")
+		return out
+	}
+
+	expectedVersion := int64(1)
+	if c.Found != nil {
+		expectedVersion = c.Found.Version + 1
+	}
+	if c.Node.Version != expectedVersion {
+		add("version (sent)", fmt.Sprintf("%d", expectedVersion), fmt.Sprintf("%d", c.Node.Version))
+	}
+	if n.Version != c.Node.Version {
+		add("version", fmt.Sprintf("%d", c.Node.Version), fmt.Sprintf("%d", n.Version))
+	}
+	if n.Changeset != c.Changeset {
+		add("changeset", fmt.Sprintf("%d", c.Changeset), fmt.Sprintf("%d", n.Changeset))
+	}
+
+	if c.Action == actionDelete {
+		if n.Visible {
+			add("visible", "false", "true")
+		}
+		return out
+	}
+
+	if !n.Visible {
+		add("visible", "true", "false")
+		return out
+	}
+
+	if roundPrec(n.Latitude, 7) != roundPrec(c.Node.Latitude, 7) {
+		add("lat", fmt.Sprintf("%.7f", c.Node.Latitude), fmt.Sprintf("%.7f", n.Latitude))
+	}
+	if roundPrec(n.Longitude, 7) != roundPrec(c.Node.Longitude, 7) {
+		add("lon", fmt.Sprintf("%.7f", c.Node.Longitude), fmt.Sprintf("%.7f", n.Longitude))
+	}
+
+	expected, actual := nodeTagMap(c.Node), nodeTagMap(n)
+	for _, k := range tagDiffKeys(expected, actual) {
+		add("tag "+k, expected[k], actual[k])
+	}
+
+	return out
+}
+
+// runVerification verifies all sent changes and prints a report of the
+// mismatches. Returns whether all nodes are as expected.
+func runVerification(out io.Writer, changes []*plannedChange, osmClient *osm.Client) bool {
+	mismatches, numNodes, err := verifyChanges(changes, osmClient)
+	if err != nil {
+		log.Errorf("Unable to verify the sent nodes: %s", err)
+		return false
+	}
+
+	if len(mismatches) == 0 {
+		log.Infof("Verified %d nodes, all nodes are as expected", numNodes)
+		return true
+	}
+
+	tw := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "Node\tWaypoint\tField\tExpected\tActual\t")
+	for _, m := range mismatches {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%q\t%q\t\n", m.NodeID, m.Waypoint, m.Field, m.Expected, m.Actual)
+	}
+	tw.Flush()
+
+	log.Errorf("Verified %d nodes, found %d mismatches", numNodes, len(mismatches))
+	return false
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+	log "github.com/Sirupsen/logrus"
+)
+
+// watchState records the checksums of all processed files to skip them
+// after a restart or when they are found again
+type watchState struct {
+	filename string
+	Files    map[string]watchEntry `json:"files"`
+}
+
+type watchEntry struct {
+	Path        string    `json:"path"`
+	ProcessedAt time.Time `json:"processed_at"`
+	Report      string    `json:"report,omitempty"`
+	Applied     bool      `json:"applied"`
+	Error       string    `json:"error,omitempty"`
+	// Retry marks files whose changes could not all be sent, they are
+	// processed again in the next scan
+	Retry bool `json:"retry,omitempty"`
+}
+
+func runWatch(args []string) {
+	if len(args) != 1 {
+		log.Fatalf("watch requires the directory to watch")
+	}
+	dir := args[0]
+
+	validateRemovalMode()
+
+	if cfg.ReportDir == "" {
+		log.Fatalf("report-dir is a required parameter for watch")
+	}
+	if err := os.MkdirAll(cfg.ReportDir, 0755); err != nil {
+		log.Fatalf("Unable to create report directory: %s", err)
+	}
+
+	state, err := loadWatchState(cfg.WatchState)
+	if err != nil {
+		log.Fatalf("Unable to read watch state: %s", err)
+	}
+
+	policy, err := loadUpdatePolicy(cfg.PolicyFile)
+	if err != nil {
+		log.Fatalf("Unable to load update policy: %s", err)
+	}
+
+	osmClient := newOSMClient()
+
+	// Nobody is watching the terminal
+	cfg.Interactive = false
+
+	if cfg.AutoApply && !cfg.NoOp {
+		log.Warnf("Auto apply is enabled, changes of new files are sent to OSM")
+	}
+
+	log.Infof("Watching %s for new or changed files every %s", dir, cfg.WatchInterval)
+	for {
+		if err := scanWatchDir(dir, state, osmClient, policy); err != nil {
+			log.Errorf("Unable to scan %s: %s", dir, err)
+		}
+		time.Sleep(cfg.WatchInterval)
+	}
+}
+
+// scanWatchDir processes all files in the directory with a supported
+// format which were not processed before. Files modified within the last
+// watch interval might still be written and are left for the next scan.
+func scanWatchDir(dir string, state *watchState, osmClient *osm.Client, policy *updatePolicy) error {
+	if _, err := os.Stat(dir); err != nil {
+		// Device not mounted
+		log.Debugf("Skipping scan: %s", err)
+		return nil
+	}
+
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		switch detectFormat(path, "") {
+		case "gpx", "kml", "kmz", "geojson":
+		default:
+			return nil
+		}
+
+		if info.IsDir() || time.Since(info.ModTime()) < cfg.WatchInterval {
+			return nil
+		}
+
+		checksum, err := fileChecksum(path)
+		if err != nil {
+			log.Errorf("Unable to read %s: %s", path, err)
+			return nil
+		}
+
+		if e, ok := state.Files[checksum]; ok {
+			if !e.Retry {
+				return nil
+			}
+			log.Infof("Retrying %s: %s", path, e.Error)
+		} else {
+			log.Infof("Processing %s", path)
+		}
+
+		entry, err := processWatchedFile(path, osmClient, policy)
+		if err != nil {
+			// Probably a temporary problem of the API, retry in the next scan
+			log.Errorf("Unable to process %s: %s", path, err)
+			return nil
+		}
+
+		state.Files[checksum] = entry
+		if err := state.Write(); err != nil {
+			return fmt.Errorf("Unable to write watch state: %s", err)
+		}
+
+		return nil
+	})
+}
+
+// processWatchedFile plans the changes of the file and writes the report.
+// The changes are only sent if auto apply is enabled. Returned errors
+// are temporary, invalid files are recorded in the entry. Entries of files
+// whose changes could not all be sent are marked to be retried.
+func processWatchedFile(path string, osmClient *osm.Client, policy *updatePolicy) (watchEntry, error) {
+	entry := watchEntry{Path: path, ProcessedAt: time.Now().UTC()}
+
+	hydrants, skipped, bds, err := readHydrants(path, "")
+	if err != nil {
+		log.Errorf("Unable to read %s: %s", path, err)
+		entry.Error = err.Error()
+		return entry, nil
+	}
+
+	changes := []*plannedChange{}
+	if len(hydrants) > 0 {
+		mapData, err := fetchMapData(osmClient, bds, mapDataBorder)
+		if err != nil {
+			return entry, fmt.Errorf("Unable to get map data: %s", err)
+		}
+
+		if changes, err = planChanges(hydrants, hydrantsFromMapData(mapData), policy, osmClient.CurrentUser.ID); err != nil {
+			return entry, fmt.Errorf("Unable to plan changes: %s", err)
+		}
+	}
+
+	for _, c := range changes {
+		logPolicyDecisions(c.Decisions)
+	}
+
+	apply := cfg.AutoApply && !cfg.NoOp
+	if err := checkStrict(skipped); apply && err != nil {
+		log.Warnf("Not applying %s: %s", path, err)
+		apply = false
+	}
+
+	var j *journal
+	if apply {
+		if j, err = openJournal(cfg.JournalFile); err != nil {
+			return entry, fmt.Errorf("Unable to read journal: %s", err)
+		}
+
+		// Every file gets its own changeset, it is opened here as a failure
+		// inside applyChanges would stop the watcher
+		changeset = nil
+		if len(changes) > 0 {
+			if _, err := openChangeset(osmClient); err != nil {
+				log.Errorf("Unable to apply %s: %s", path, err)
+				entry.Error, entry.Retry = err.Error(), true
+				apply = false
+			}
+		}
+	}
+
+	if apply {
+		if _, errs := applyChanges(changes, osmClient, j); len(errs) > 0 {
+			entry.Error, entry.Retry = fmt.Sprintf("%d changes failed", len(errs)), true
+		}
+		entry.Applied = true
+	} else {
+		logReviewList(changes)
+	}
+
+	report := newRunReport(changes, skipped)
+	report.Source, report.NoOp = path, !apply
+
+	format := cfg.ReportFormat
+	if format == "" {
+		format = "html"
+	}
+	entry.Report = filepath.Join(cfg.ReportDir, fmt.Sprintf("%s-%s.%s",
+		strings.TrimSuffix(filepath.Base(path), filepath.Ext(path)), entry.ProcessedAt.Format("20060102-150405"), format))
+
+	if err := writeRunReport(entry.Report, format, report); err != nil {
+		log.Errorf("Unable to write report: %s", err)
+		entry.Report = ""
+	} else {
+		log.Infof("Wrote report of %s to %s", path, entry.Report)
+	}
+
+	return entry, nil
+}
+
+func fileChecksum(path string) (string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func loadWatchState(filename string) (*watchState, error) {
+	s := &watchState{filename: filename, Files: map[string]watchEntry{}}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return s, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	if err := json.NewDecoder(f).Decode(s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Write replaces the state file through a temporary file
+func (s *watchState) Write() error {
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(s.filename+".tmp", data, 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(s.filename+".tmp", s.filename)
+}
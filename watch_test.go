@@ -0,0 +1,119 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Luzifer/gpxhydrant/osm"
+)
+
+// watchTestAPI is a minimal OSM API without any map data. Creating a
+// changeset fails while failChangesets is set.
+type watchTestAPI struct {
+	failChangesets bool
+	createdNodes   int
+}
+
+func (a *watchTestAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method + " " + r.URL.Path {
+	case "GET /user/details":
+		fmt.Fprint(w, `<osm><user id="1" display_name="me"></user></osm>`)
+	case "GET /map":
+		fmt.Fprint(w, `<osm></osm>`)
+	case "PUT /changeset/create":
+		if a.failChangesets {
+			http.Error(w, "Database offline", http.StatusInternalServerError)
+			return
+		}
+		fmt.Fprint(w, "51")
+	case "GET /changeset/51":
+		fmt.Fprint(w, `<osm><changeset id="51" open="true"></changeset></osm>`)
+	case "PUT /changeset/51":
+	case "PUT /node/create":
+		a.createdNodes++
+		fmt.Fprintf(w, "%d", 1000+a.createdNodes)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+func TestScanWatchDirRetriesFailedApply(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gpxhydrant")
+	if err != nil {
+		t.Fatalf("Unable to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	watchDir := filepath.Join(dir, "watch")
+	if err := os.Mkdir(watchDir, 0755); err != nil {
+		t.Fatalf("Unable to create watch dir: %s", err)
+	}
+
+	filename := filepath.Join(watchDir, "survey.gpx")
+	if err := ioutil.WriteFile(filename, []byte(captureTestGPX), 0644); err != nil {
+		t.Fatalf("Unable to write GPX file: %s", err)
+	}
+	old := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(filename, old, old); err != nil {
+		t.Fatalf("Unable to change file time: %s", err)
+	}
+
+	origCfg := cfg
+	defer func() { cfg, changeset = origCfg, nil }()
+
+	cfg.AutoApply, cfg.NoOp = true, false
+	cfg.ReportDir, cfg.ReportFormat, cfg.JournalFile = dir, "json", filepath.Join(dir, "journal")
+
+	api := &watchTestAPI{failChangesets: true}
+	server := httptest.NewServer(api)
+	defer server.Close()
+
+	osmClient, err := osm.NewWithAPIEndpoint("me", "secret", server.URL)
+	if err != nil {
+		t.Fatalf("Unable to create OSM client: %s", err)
+	}
+
+	state := &watchState{filename: filepath.Join(dir, "state.json"), Files: map[string]watchEntry{}}
+
+	scan := func() watchEntry {
+		if err := scanWatchDir(watchDir, state, osmClient, nil); err != nil {
+			t.Fatalf("Scan failed: %s", err)
+		}
+		if len(state.Files) != 1 {
+			t.Fatalf("Expected one file in the state, got %d", len(state.Files))
+		}
+		for _, e := range state.Files {
+			return e
+		}
+		return watchEntry{}
+	}
+
+	// Changeset can not be created: the file stays in the state for a retry
+	if e := scan(); !e.Retry || e.Applied || e.Error == "" {
+		t.Errorf("Expected failed apply to be marked for retry, got %#v", e)
+	}
+	if api.createdNodes != 0 {
+		t.Errorf("Expected no nodes to be created, got %d", api.createdNodes)
+	}
+
+	// API is back: the file is applied in the next scan
+	api.failChangesets = false
+	if e := scan(); e.Retry || !e.Applied || e.Error != "" {
+		t.Errorf("Expected retried file to be applied, got %#v", e)
+	}
+	if api.createdNodes != 1 {
+		t.Errorf("Expected one node to be created, got %d", api.createdNodes)
+	}
+
+	// Applied files are not processed again
+	scan()
+	if api.createdNodes != 1 {
+		t.Errorf("Expected applied file not to be processed again, got %d created nodes", api.createdNodes)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+)
+
+// hydrantZones contains the zones from the zones-file ordered by
+// descending priority
+var hydrantZones []*zone
+
+type zoneConfig struct {
+	Zones []*zone `yaml:"zones"`
+}
+
+// zone assigns default tags to all hydrants inside its area
+type zone struct {
+	Name string `yaml:"name"`
+	// Area is a GeoJSON or .poly file, relative to the zones file
+	Area     string `yaml:"area"`
+	Priority int    `yaml:"priority"`
+
+	Operator    string `yaml:"operator"`
+	Pressure    int64  `yaml:"pressure"`
+	WaterSource string `yaml:"water_source"`
+	RefPrefix   string `yaml:"ref_prefix"`
+
+	area *area
+}
+
+func loadZones(filename string) ([]*zone, error) {
+	if filename == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c := zoneConfig{}
+	if err := yaml.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	for i, z := range c.Zones {
+		if z.Name == "" {
+			z.Name = fmt.Sprintf("zone %d", i+1)
+		}
+
+		if z.Area == "" {
+			return nil, fmt.Errorf("%s has no area", z.Name)
+		}
+		if z.Pressure < 0 {
+			return nil, fmt.Errorf("%s has an invalid pressure", z.Name)
+		}
+
+		areaFile := z.Area
+		if !filepath.IsAbs(areaFile) {
+			areaFile = filepath.Join(filepath.Dir(filename), areaFile)
+		}
+		if z.area, err = loadArea(areaFile); err != nil {
+			return nil, fmt.Errorf("Unable to load area of %s: %s", z.Name, err)
+		}
+	}
+
+	sort.SliceStable(c.Zones, func(i, j int) bool { return c.Zones[i].Priority > c.Zones[j].Priority })
+
+	return c.Zones, nil
+}
+
+// zoneValue returns the value of the zone with the highest priority
+// containing the hydrant and having the value set
+func zoneValue(h *hydrant, value func(*zone) string) (string, *zone) {
+	for _, z := range hydrantZones {
+		if v := value(z); v != "" && z.area.Contains(h.Latitude, h.Longitude) {
+			return v, z
+		}
+	}
+	return "", nil
+}
+
+// applyZoneDefaults sets the operator, pressure and water source of the
+// zones containing the hydrant and marks them as defaults. Needs to be
+// called before the explicit tags of the waypoint are set to have them
+// override the defaults.
+func applyZoneDefaults(h *hydrant) error {
+	fields := map[string]func(*zone) string{
+		"operator":              func(z *zone) string { return z.Operator },
+		"fire_hydrant:pressure": func(z *zone) string { return formatZonePressure(z.Pressure) },
+		"water_source":          func(z *zone) string { return z.WaterSource },
+	}
+
+	for key, value := range fields {
+		v, z := zoneValue(h, value)
+		if z == nil {
+			continue
+		}
+
+		log.Debugf("Waypoint %s: %s=%s from %s", h.Name, key, v, z.Name)
+		if err := h.setTag(key, v); err != nil {
+			return err
+		}
+
+		if h.ZoneDefaults == nil {
+			h.ZoneDefaults = map[string]bool{}
+		}
+		h.ZoneDefaults[key] = true
+	}
+
+	return nil
+}
+
+// applyZoneRefPrefix prefixes the ref of the hydrant with the ref prefix
+// of its zone unless the ref already starts with it
+func applyZoneRefPrefix(h *hydrant) {
+	ref := h.Tags["ref"]
+	if ref == "" {
+		return
+	}
+
+	prefix, z := zoneValue(h, func(z *zone) string { return z.RefPrefix })
+	if z == nil || strings.HasPrefix(ref, prefix) {
+		return
+	}
+
+	log.Debugf("Waypoint %s: ref prefix %s from %s", h.Name, prefix, z.Name)
+	h.Tags["ref"] = prefix + ref
+}
+
+func formatZonePressure(p int64) string {
+	if p == 0 {
+		return ""
+	}
+	return fmt.Sprintf("%d", p)
+}